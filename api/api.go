@@ -0,0 +1,205 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	rds "github.com/garyburd/redigo/redis"
+	"github.com/tokopedia/redisgrator/config"
+	"github.com/tokopedia/redisgrator/connection"
+	"github.com/tokopedia/redisgrator/handler"
+	"github.com/tokopedia/redisgrator/logger"
+	"github.com/tokopedia/redisgrator/metrics"
+	"github.com/tokopedia/redisgrator/phase"
+	"github.com/tokopedia/redisgrator/verify"
+)
+
+// header carrying admin password for admin endpoint
+const passwordHeader = "X-Admin-Password"
+
+// listen address when host is not set, the api is only reachable from the proxy host
+const defaultHost = "127.0.0.1"
+
+var start time.Time
+
+// serve http admin and status api at given host and port, block until server stopped
+func ListenAndServe(host string, port int, startTime time.Time) error {
+	start = startTime
+	if host == "" {
+		host = defaultHost
+	}
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+	logger.Info("starting http api", "addr", addr)
+	return http.ListenAndServe(addr, newMux())
+}
+
+func newMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", health)
+	mux.HandleFunc("/ready", ready)
+	mux.HandleFunc("/config", adminOnly(http.MethodGet, currentConfig))
+	mux.HandleFunc("/progress", progress)
+	mux.HandleFunc("/stats", stats)
+	mux.HandleFunc("/metrics", promMetrics)
+	mux.HandleFunc("/move", adminOnly(http.MethodPost, move))
+	mux.HandleFunc("/verify", adminOnly(http.MethodPost, verifyKey))
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		logger.Error("http api : err when write response", "err", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+// only allow method with valid admin password
+func adminOnly(method string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		adminPassword := config.CurrentGeneral().AdminPassword
+		if adminPassword == "" {
+			writeError(w, http.StatusForbidden, "admin api disabled, AdminPassword is not set")
+			return
+		}
+		password := r.Header.Get(passwordHeader)
+		if subtle.ConstantTimeCompare([]byte(password), []byte(adminPassword)) != 1 {
+			writeError(w, http.StatusUnauthorized, "invalid admin password")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// GET /health
+func health(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":            "ok",
+		"uptime_in_seconds": int(time.Since(start).Seconds()),
+	})
+}
+
+// GET /ready, ready while at least one of origin and destination answer PING,
+// with one side down proxy still serve from the other and report degraded
+func ready(w http.ResponseWriter, r *http.Request) {
+	res := map[string]interface{}{}
+	breaker := map[string]string{}
+	up := 0
+	for name, pool := range map[string]interface {
+		Get() rds.Conn
+		State() string
+	}{
+		"origin":      connection.RedisPoolConnection.Origin,
+		"destination": connection.RedisPoolConnection.Destination,
+	} {
+		breaker[name] = pool.State()
+		conn := pool.Get()
+		_, err := conn.Do("PING")
+		conn.Close()
+		if err != nil {
+			res[name] = err.Error()
+			continue
+		}
+		res[name] = "ok"
+		up++
+	}
+	res["breaker"] = breaker
+	res["degraded"] = up < 2
+	status := http.StatusOK
+	if up == 0 {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, res)
+}
+
+// GET /config, admin only, admin password is never exposed
+func currentConfig(w http.ResponseWriter, r *http.Request) {
+	cfg := config.Cfg
+	cfg.General = config.CurrentGeneral()
+	if cfg.General.AdminPassword != "" {
+		cfg.General.AdminPassword = "*****"
+	}
+	writeJSON(w, http.StatusOK, cfg)
+}
+
+// GET /progress
+func progress(w http.ResponseWriter, r *http.Request) {
+	res := map[string]interface{}{
+		"phase": phase.Current().String(),
+		"moved": metrics.Moved.Snapshot(),
+	}
+	conn := connection.RedisPoolConnection.Origin.Get()
+	if n, err := rds.Int64(conn.Do("DBSIZE")); err == nil {
+		res["origin_keys"] = n
+	}
+	conn.Close()
+	conn = connection.RedisPoolConnection.Destination.Get()
+	if n, err := rds.Int64(conn.Do("DBSIZE")); err == nil {
+		res["destination_keys"] = n
+	}
+	conn.Close()
+	writeJSON(w, http.StatusOK, res)
+}
+
+// GET /stats
+func stats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"commands":                       metrics.Commands.Snapshot(),
+		"total_commands":                 metrics.Commands.Total(),
+		"origin_active_connections":      connection.RedisPoolConnection.Origin.ActiveCount(),
+		"destination_active_connections": connection.RedisPoolConnection.Destination.ActiveCount(),
+	})
+}
+
+// GET /metrics in prometheus text format
+func promMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	metrics.WritePrometheus(w)
+}
+
+// POST /move?key=<key>
+func move(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		writeError(w, http.StatusBadRequest, "key is required")
+		return
+	}
+	typ, err := handler.MoveKey(key)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"key": key, "type": typ})
+}
+
+// POST /verify?key=<key>
+func verifyKey(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		writeError(w, http.StatusBadRequest, "key is required")
+		return
+	}
+	m, err := verify.Key(key)
+	if err == verify.ErrNotFound {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"key": key, "match": m == nil, "mismatch": m})
+}
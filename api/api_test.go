@@ -0,0 +1,63 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/tokopedia/redisgrator/config"
+)
+
+func TestConfigAdminOnly(t *testing.T) {
+	defer func(g config.General) { config.Cfg.General = g }(config.Cfg.General)
+	tests := []struct {
+		name          string
+		adminPassword string
+		method        string
+		password      string
+		want          int
+	}{
+		{"no password", "secret", http.MethodGet, "", http.StatusUnauthorized},
+		{"wrong password", "secret", http.MethodGet, "nope", http.StatusUnauthorized},
+		{"admin disabled", "", http.MethodGet, "", http.StatusForbidden},
+		{"wrong method", "secret", http.MethodPost, "secret", http.StatusMethodNotAllowed},
+		{"valid password", "secret", http.MethodGet, "secret", http.StatusOK},
+	}
+	mux := newMux()
+	for _, tt := range tests {
+		config.Cfg.General.AdminPassword = tt.adminPassword
+		r := httptest.NewRequest(tt.method, "/config", nil)
+		if tt.password != "" {
+			r.Header.Set(passwordHeader, tt.password)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%s : got status %d, want %d", tt.name, w.Code, tt.want)
+			continue
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+		var cfg config.Config
+		if err := json.Unmarshal(w.Body.Bytes(), &cfg); err != nil {
+			t.Fatal(err)
+		}
+		if cfg.General.AdminPassword != "*****" {
+			t.Errorf("got admin password %q in config, want it masked", cfg.General.AdminPassword)
+		}
+	}
+}
+
+func TestStatusEndpointsOpen(t *testing.T) {
+	defer func(g config.General) { config.Cfg.General = g }(config.Cfg.General)
+	config.Cfg.General.AdminPassword = "secret"
+	for _, path := range []string{"/health", "/metrics"} {
+		w := httptest.NewRecorder()
+		newMux().ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("%s : got status %d, want 200 without password", path, w.Code)
+		}
+	}
+}
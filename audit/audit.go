@@ -0,0 +1,164 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/tokopedia/redisgrator/logger"
+)
+
+const (
+	// whole key copied or moved to target
+	ActionMove = "move"
+	// key deleted from source after moved
+	ActionDelete = "delete"
+	// write mirrored to source because of Duplicate
+	ActionDuplicate = "duplicate"
+)
+
+type Entry struct {
+	Time   time.Time `json:"time"`
+	Action string    `json:"action"`
+	Key    string    `json:"key"`
+	Type   string    `json:"type"`
+	// byte length for string, number of field or member for hash and set
+	Size int `json:"size"`
+	// ttl in seconds on source before action, -1 no expiry, -2 not exist
+	TTL int64 `json:"ttl"`
+	// upstream written by the action, origin or destination
+	Side    string `json:"side"`
+	Outcome string `json:"outcome"`
+}
+
+type auditLog struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	f          *os.File
+	size       int64
+}
+
+var current *auditLog
+
+// open audit file, rotated once it grows over maxSize bytes keeping maxBackups old file
+func Open(path string, maxSize int64, maxBackups int) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	current = &auditLog{path: path, maxSize: maxSize, maxBackups: maxBackups, f: f, size: st.Size()}
+	return nil
+}
+
+func Enabled() bool {
+	return current != nil
+}
+
+// append entry, failure is only logged so it never break proxied request
+func Record(e Entry) {
+	if current == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		logger.Error("audit : err when encode entry", "err", err)
+		return
+	}
+	b = append(b, '\n')
+
+	a := current
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.maxSize > 0 && a.size+int64(len(b)) > a.maxSize {
+		if err := a.rotate(); err != nil {
+			logger.Error("audit : err when rotate", "err", err)
+		}
+	}
+	n, err := a.f.Write(b)
+	a.size += int64(n)
+	if err != nil {
+		logger.Error("audit : err when write entry", "err", err)
+	}
+}
+
+// path.1 is the newest backup, path.<maxBackups> the oldest
+func (a *auditLog) rotate() error {
+	if err := a.f.Close(); err != nil {
+		return err
+	}
+	for i := a.maxBackups - 1; i >= 1; i-- {
+		os.Rename(backup(a.path, i), backup(a.path, i+1))
+	}
+	if a.maxBackups > 0 {
+		os.Rename(a.path, backup(a.path, 1))
+	} else {
+		os.Remove(a.path)
+	}
+	f, err := os.OpenFile(a.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	a.f, a.size = f, 0
+	return nil
+}
+
+func backup(path string, i int) string {
+	return path + "." + strconv.Itoa(i)
+}
+
+// find entries of key from oldest backup to current file, keep only last limit entries
+func Search(key string, limit int) ([]Entry, error) {
+	if current == nil {
+		return nil, nil
+	}
+	a := current
+	a.mu.Lock()
+	files := []string{}
+	for i := a.maxBackups; i >= 1; i-- {
+		files = append(files, backup(a.path, i))
+	}
+	files = append(files, a.path)
+	a.mu.Unlock()
+
+	var res []Entry
+	for _, path := range files {
+		f, err := os.Open(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		sc := bufio.NewScanner(f)
+		sc.Buffer(make([]byte, 64*1024), 1024*1024)
+		for sc.Scan() {
+			var e Entry
+			if json.Unmarshal(sc.Bytes(), &e) != nil || e.Key != key {
+				continue
+			}
+			res = append(res, e)
+			if limit > 0 && len(res) > limit {
+				res = res[1:]
+			}
+		}
+		err = sc.Err()
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,149 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var at = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func open(t *testing.T, path string, maxSize int64, maxBackups int) {
+	if err := Open(path, maxSize, maxBackups); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if current != nil {
+			current.f.Close()
+			current = nil
+		}
+	})
+}
+
+func entry(key, action string) Entry {
+	return Entry{Time: at, Action: action, Key: key, Type: "string", Side: "destination", Outcome: "ok"}
+}
+
+func keys(t *testing.T, path string) []string {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var res []string
+	dec := json.NewDecoder(bytes.NewReader(b))
+	for dec.More() {
+		var e Entry
+		if err := dec.Decode(&e); err != nil {
+			t.Fatal(err)
+		}
+		res = append(res, e.Key)
+	}
+	return res
+}
+
+func TestRotate(t *testing.T) {
+	b, _ := json.Marshal(entry("k0", ActionMove))
+	line := int64(len(b) + 1)
+	tests := []struct {
+		name       string
+		maxBackups int
+		// key in current file then path.1, path.2, ...
+		want [][]string
+	}{
+		{"two backups", 2, [][]string{{"k6"}, {"k4", "k5"}, {"k2", "k3"}, nil}},
+		{"no backup", 0, [][]string{{"k6"}, nil}},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "audit")
+		//room for two entries per file
+		open(t, path, 2*line, tt.maxBackups)
+		for i := 0; i < 7; i++ {
+			Record(entry("k"+strconv.Itoa(i), ActionMove))
+		}
+		for i, want := range tt.want {
+			p := path
+			if i > 0 {
+				p = backup(path, i)
+			}
+			if got := keys(t, p); !equal(got, want) {
+				t.Errorf("%s : got %v in %s, want %v", tt.name, got, filepath.Base(p), want)
+			}
+		}
+		current.f.Close()
+		current = nil
+	}
+}
+
+func TestOpenKeepSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit")
+	b, _ := json.Marshal(entry("k0", ActionMove))
+	line := int64(len(b) + 1)
+	open(t, path, 2*line, 1)
+	Record(entry("k0", ActionMove))
+	Record(entry("k1", ActionMove))
+	current.f.Close()
+
+	//file filled before restart is rotated by the next entry
+	open(t, path, 2*line, 1)
+	Record(entry("k2", ActionMove))
+	if got := keys(t, path); !equal(got, []string{"k2"}) {
+		t.Errorf("got %v after restart, want [k2]", got)
+	}
+	if got := keys(t, backup(path, 1)); !equal(got, []string{"k0", "k1"}) {
+		t.Errorf("got %v in backup, want [k0 k1]", got)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit")
+	b, _ := json.Marshal(entry("a", ActionMove))
+	open(t, path, 3*int64(len(b)+1), 3)
+	Record(entry("a", ActionMove))
+	Record(entry("b", ActionMove))
+	Record(entry("a", ActionDelete))
+	//corrupted line is skipped
+	current.f.WriteString("{\"key\":\"a\"\n")
+	Record(entry("a", ActionDuplicate))
+	Record(entry("ab", ActionMove))
+
+	tests := []struct {
+		key   string
+		limit int
+		want  []string
+	}{
+		//oldest backup first, across rotated file
+		{"a", 0, []string{ActionMove, ActionDelete, ActionDuplicate}},
+		{"a", 2, []string{ActionDelete, ActionDuplicate}},
+		{"b", 0, []string{ActionMove}},
+		{"none", 0, nil},
+	}
+	for _, tt := range tests {
+		got, err := Search(tt.key, tt.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var actions []string
+		for _, e := range got {
+			actions = append(actions, e.Action)
+		}
+		if !equal(actions, tt.want) {
+			t.Errorf("Search(%q, %d) = %v, want %v", tt.key, tt.limit, actions, tt.want)
+		}
+	}
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,136 @@
+package batch
+
+import (
+	"net"
+	"strings"
+
+	"github.com/tokopedia/redisgrator/logger"
+	"github.com/tokopedia/redisgrator/resp"
+)
+
+// client bytes buffered while waiting for the rest of a command, batching is given up above it
+const maxCarry = 8 * 1024 * 1024
+
+// answer many GET at once, reply and error are in key order
+type Getter interface {
+	GetBatch(keys []string) ([][]byte, []error)
+}
+
+// wrap client connection so run of pipelined GET is answered with h.GetBatch
+func NewConn(conn net.Conn, h Getter) net.Conn {
+	return &pipelineConn{Conn: conn, h: h}
+}
+
+// client connection answering run of GET in a pipeline itself, with one pipelined round trip
+// per upstream, every other command is handed to the server that process one command at a time.
+// only complete command is handed to the server, so once the server read again it has replied
+// to everything before and reply written here stay in client order
+type pipelineConn struct {
+	net.Conn
+	h Getter
+	// client bytes read but not parsed into complete command yet
+	carry []byte
+	// complete command waiting to be read by the server
+	out []byte
+	err error
+	// client bytes can't be parsed, everything is handed to the server as is
+	broken bool
+}
+
+func (c *pipelineConn) Read(b []byte) (int, error) {
+	buf := make([]byte, 16*1024)
+	for {
+		if len(c.out) > 0 {
+			n := copy(b, c.out)
+			c.out = c.out[n:]
+			return n, nil
+		}
+		if c.broken {
+			if c.err != nil {
+				return 0, c.err
+			}
+			return c.Conn.Read(b)
+		}
+		//command already buffered go first, socket is only read once none is complete
+		c.dispatch()
+		if len(c.out) > 0 || c.broken {
+			continue
+		}
+		if c.err != nil {
+			if len(c.carry) > 0 {
+				//incomplete trailing command, let the server deal with it
+				c.out, c.carry = c.carry, nil
+				continue
+			}
+			//server sees the error once every complete command is handed over
+			return 0, c.err
+		}
+		n, err := c.Conn.Read(buf)
+		c.carry = append(c.carry, buf[:n]...)
+		c.err = err
+	}
+}
+
+// answer leading run of GET, then move command up to the next run of GET to out
+func (c *pipelineConn) dispatch() {
+	for len(c.carry) > 0 && len(c.out) == 0 {
+		var keys []string
+		pos := 0
+		for pos < len(c.carry) {
+			args, size, err := resp.ParseCommand(c.carry[pos:])
+			if err == resp.ErrIncomplete {
+				break
+			}
+			if err != nil {
+				logger.Debug("pipeline : stop batching on unparsable client input", "err", err)
+				c.giveUp()
+				return
+			}
+			if len(args) != 2 || !strings.EqualFold(args[0], "GET") {
+				break
+			}
+			keys = append(keys, args[1])
+			pos += size
+		}
+		if len(keys) > 1 {
+			c.reply(keys)
+			c.carry = c.carry[pos:]
+			continue
+		}
+		// single GET or other command, server handle up to the next complete command
+		_, size, err := resp.ParseCommand(c.carry)
+		if err == resp.ErrIncomplete {
+			if len(c.carry) > maxCarry {
+				c.giveUp()
+			}
+			return
+		}
+		if err != nil {
+			c.giveUp()
+			return
+		}
+		c.out = append(c.out, c.carry[:size]...)
+		c.carry = c.carry[size:]
+	}
+}
+
+func (c *pipelineConn) reply(keys []string) {
+	vals, errs := c.h.GetBatch(keys)
+	var b []byte
+	for i := range keys {
+		if errs[i] != nil {
+			b = resp.AppendError(b, errs[i])
+			continue
+		}
+		b = resp.AppendBulk(b, vals[i])
+	}
+	if _, err := c.Conn.Write(b); err != nil {
+		c.err = err
+	}
+}
+
+func (c *pipelineConn) giveUp() {
+	c.broken = true
+	c.out = append(c.out, c.carry...)
+	c.carry = nil
+}
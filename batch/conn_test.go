@@ -0,0 +1,131 @@
+package batch
+
+import (
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tokopedia/redisgrator/resp"
+)
+
+// client sending scripted chunks, it waits for every reply before running out of input
+type fakeClient struct {
+	net.Conn
+	t       *testing.T
+	chunks  []string
+	want    string
+	written []byte
+}
+
+func (c *fakeClient) Read(b []byte) (int, error) {
+	if len(c.chunks) == 0 {
+		if len(c.written) < len(c.want) {
+			c.t.Fatalf("read client socket with reply pending, got %q so far, want %q", c.written, c.want)
+		}
+		return 0, io.EOF
+	}
+	n := copy(b, c.chunks[0])
+	if c.chunks[0] = c.chunks[0][n:]; c.chunks[0] == "" {
+		c.chunks = c.chunks[1:]
+	}
+	return n, nil
+}
+
+func (c *fakeClient) Write(b []byte) (int, error) {
+	c.written = append(c.written, b...)
+	return len(b), nil
+}
+
+type fakeGetter struct {
+	batches [][]string
+}
+
+func (g *fakeGetter) GetBatch(keys []string) ([][]byte, []error) {
+	g.batches = append(g.batches, keys)
+	vals := make([][]byte, len(keys))
+	errs := make([]error, len(keys))
+	for i, key := range keys {
+		if key == "bad" {
+			errs[i] = errors.New("down")
+			continue
+		}
+		vals[i] = []byte("v:" + key)
+	}
+	return vals, errs
+}
+
+// server processing one command at a time like go-redis-server, it only read again
+// once every complete command read so far is replied, reply to "CMD a b" is "+CMD a b"
+func serve(c net.Conn) []byte {
+	var data []byte
+	buf := make([]byte, 64)
+	for {
+		n, err := c.Read(buf)
+		data = append(data, buf[:n]...)
+		for {
+			args, size, perr := resp.ParseCommand(data)
+			if perr != nil {
+				break
+			}
+			c.Write([]byte("+" + strings.Join(args, " ") + "\r\n"))
+			data = data[size:]
+		}
+		if err != nil {
+			return data
+		}
+	}
+}
+
+func TestConnRead(t *testing.T) {
+	tests := []struct {
+		name     string
+		chunks   []string
+		want     string
+		batches  [][]string
+		leftover string
+	}{
+		{"non GET then GET batch", []string{"SET k v\r\nGET a\r\nGET b\r\n"},
+			"+SET k v\r\n$3\r\nv:a\r\n$3\r\nv:b\r\n", [][]string{{"a", "b"}}, ""},
+		{"non GET then single GET", []string{"SET k v\r\nGET a\r\n"},
+			"+SET k v\r\n+GET a\r\n", nil, ""},
+		{"single GET then command", []string{"GET a\r\nSET k v\r\n"},
+			"+GET a\r\n+SET k v\r\n", nil, ""},
+		{"GET batch between writes", []string{"SET x 1\r\nGET a\r\nGET b\r\nGET c\r\nDEL x\r\n"},
+			"+SET x 1\r\n$3\r\nv:a\r\n$3\r\nv:b\r\n$3\r\nv:c\r\n+DEL x\r\n", [][]string{{"a", "b", "c"}}, ""},
+		{"error in batch", []string{"GET a\r\nGET bad\r\n"},
+			"$3\r\nv:a\r\n-ERROR down\r\n", [][]string{{"a", "bad"}}, ""},
+		{"command split across reads", []string{"GET a\r\nGE", "T b\r\nSET k v\r\n"},
+			"+GET a\r\n+GET b\r\n+SET k v\r\n", nil, ""},
+		{"batch split across reads", []string{"GET a\r\nGET b\r\nGET", " c\r\nGET d\r\n"},
+			"$3\r\nv:a\r\n$3\r\nv:b\r\n$3\r\nv:c\r\n$3\r\nv:d\r\n", [][]string{{"a", "b"}, {"c", "d"}}, ""},
+		//GET c is complete alone in the first read, it is handed to the server without waiting for GET d
+		{"reply order across batches and commands", []string{"GET a\r\nGET b\r\nSET k v\r\nGET c\r\nGE",
+			"T d\r\nGET e\r\nDEL k\r\nGET f\r\nINCR n\r\nGET g\r\nGET h\r\n"},
+			"$3\r\nv:a\r\n$3\r\nv:b\r\n+SET k v\r\n+GET c\r\n$3\r\nv:d\r\n$3\r\nv:e\r\n" +
+				"+DEL k\r\n+GET f\r\n+INCR n\r\n$3\r\nv:g\r\n$3\r\nv:h\r\n",
+			[][]string{{"a", "b"}, {"d", "e"}, {"g", "h"}}, ""},
+		{"partial trailing command", []string{"GET a\r\nGET b\r\n*2\r\n$3\r\nGE"},
+			"$3\r\nv:a\r\n$3\r\nv:b\r\n", [][]string{{"a", "b"}}, "*2\r\n$3\r\nGE"},
+		{"unparsable input handed as is", []string{"SET k v\r\n*-1\r\nGET a\r\nGET b\r\n"},
+			"+SET k v\r\n", nil, "*-1\r\nGET a\r\nGET b\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &fakeClient{t: t, chunks: tt.chunks, want: tt.want}
+			g := &fakeGetter{}
+			leftover := serve(NewConn(client, g))
+			if string(client.written) != tt.want {
+				t.Errorf("got reply %q, want %q", client.written, tt.want)
+			}
+			if !reflect.DeepEqual(g.batches, tt.batches) {
+				t.Errorf("got batches %q, want %q", g.batches, tt.batches)
+			}
+			if string(leftover) != tt.leftover {
+				t.Errorf("server got leftover %q, want %q", leftover, tt.leftover)
+			}
+		})
+	}
+}
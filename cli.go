@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/tokopedia/redisgrator/handler"
+	"github.com/tokopedia/redisgrator/verify"
+)
+
+// redisgrator verify [-key key] [-pattern pattern] [-rate n] [-count n]
+// print one json mismatch per line followed by json summary,
+// exit 1 when mismatch found and 2 on error
+func verifyCommand(args []string) int {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	key := fs.String("key", "", "verify single key")
+	pattern := fs.String("pattern", "", "verify keys matching SCAN MATCH pattern, default whole keyspace")
+	rate := fs.Int("rate", 100, "max key compared per second, 0 for unlimited")
+	count := fs.Int("count", 100, "SCAN COUNT hint")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	enc := json.NewEncoder(os.Stdout)
+
+	if *key != "" {
+		m, err := verify.Key(*key)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "verify :", err)
+			return 2
+		}
+		if m == nil {
+			return 0
+		}
+		enc.Encode(m)
+		return 1
+	}
+
+	sum, err := verify.Scan(verify.Options{Pattern: *pattern, Rate: *rate, Count: *count}, nil, func(m verify.Mismatch) {
+		enc.Encode(m)
+	}, nil)
+	enc.Encode(sum)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "verify :", err)
+		return 2
+	}
+	if sum.Mismatches > 0 {
+		return 1
+	}
+	return 0
+}
+
+// redisgrator repair [-dry-run] [-file report]
+// read verifier output (json lines, default stdin) and repair every mismatched key,
+// print one json result per line, exit 2 when any repair failed
+func repairCommand(args []string) int {
+	fs := flag.NewFlagSet("repair", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "only print what would be changed")
+	file := fs.String("file", "", "verifier output, default stdin")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	in := os.Stdin
+	if *file != "" {
+		f, err := os.Open(*file)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "repair :", err)
+			return 2
+		}
+		defer f.Close()
+		in = f
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	dec := json.NewDecoder(in)
+	code := 0
+	for {
+		var m verify.Mismatch
+		err := dec.Decode(&m)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "repair : invalid verifier output :", err)
+			return 2
+		}
+		//summary line has no kind
+		if m.Key == "" || m.Kind == "" {
+			continue
+		}
+		res, err := handler.RepairKey(m.Key, *dryRun)
+		if err != nil {
+			res.Error = err.Error()
+			code = 2
+		}
+		enc.Encode(res)
+	}
+	return code
+}
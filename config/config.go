@@ -1,8 +1,16 @@
 package config
 
 import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/tokopedia/redisgrator/logger"
 	"gopkg.in/gcfg.v1"
-	"log"
 )
 
 type RedisHostCfg struct {
@@ -18,34 +26,269 @@ type General struct {
 	Duplicate        bool
 	MaxSema          int
 	TimeoutSema      int64
+	// compare destination with origin on every read while origin is authoritative
+	ShadowRead bool
+	// hash, set and zset with more element than this are moved in chunk of ChunkSize, 0 disable it
+	BigKeyThreshold int
+	ChunkSize       int
+	// command sent per round trip by pipelined write, like chunk of big key and Duplicate mirroring
+	PipelineBatch int
+	// answer run of GET in a client pipeline with one pipelined round trip per upstream
+	BatchPipeline bool
+	// initial migration phase, overridden by PhaseFile once phase changed at runtime
+	Phase     string
+	PhaseFile string
+	// password for REDISGRATOR admin command, admin command disabled when empty
+	AdminPassword string
+	// address and port of http admin and status api, empty host listen on 127.0.0.1 only, port 0 disable it
+	HTTPHost string
+	HTTPPort int
+	// command slower than this many microseconds go to SLOWLOG, negative disable it
+	SlowlogSlowerThan int64
+	// number of entries kept by SLOWLOG, 0 disable it
+	SlowlogMaxLen int
+	// write while one upstream breaker is open: reject it, or queue the write of the down side
+	// to Journal and replay it once back (needs Journal File), write is always rejected while primary side is down
+	DegradedWrite string
+}
+
+// rewrite key name from origin to destination,
+// every * in From is substituted into the matching * in To
+type RewriteCfg struct {
+	From     string
+	To       string
+	Priority int
+}
+
+// boolean option that can be left unset, used to override General per key
+type OptBool struct {
+	Set   bool
+	Value bool
+}
+
+func (b *OptBool) UnmarshalText(text []byte) error {
+	v, err := strconv.ParseBool(string(text))
+	if err != nil {
+		return err
+	}
+	b.Set, b.Value = true, v
+	return nil
+}
+
+// get option value or fallback when not set
+func (b OptBool) Or(fallback bool) bool {
+	if b.Set {
+		return b.Value
+	}
+	return fallback
+}
+
+// migration policy for keys matching Pattern, unset option follow General
+type PolicyCfg struct {
+	Pattern          string
+	Priority         int
+	SetToDestWhenGet OptBool
+	MoveHash         OptBool
+	MoveSet          OptBool
+	Duplicate        OptBool
+	// false keep key only in origin, never touch destination
+	Migrate OptBool
+}
+
+type LogCfg struct {
+	// debug, info, warn or error, proxied command logged at debug
+	Level string
+	// text or json
+	Format string
+	// log size of value instead of the value itself
+	RedactValues bool
+	// key pattern logged as hash instead of name
+	RedactKey []string
+	// log 1 of n command, written as "<COMMAND> <n>"
+	Sample []string
+}
+
+// audit log of every key moved, deleted or duplicated, disabled when File is empty
+type AuditCfg struct {
+	File string
+	// rotate file once bigger than MaxSizeMB
+	MaxSizeMB  int64
+	MaxBackups int
+}
+
+type TraceCfg struct {
+	// none, stdout or file
+	Exporter string
+	File     string
+	// fraction of command traced, 0 to 1
+	SampleRatio float64
+}
+
+// connection pool of one upstream, section name is origin or destination,
+// unset size and idle timeout keep the default and zero timeout wait forever
+type PoolCfg struct {
+	// connection open at once per upstream, 0 is unlimited
+	MaxActive int
+	MaxIdle   int
+	// wait for free connection once MaxActive is reached instead of failing the command
+	Wait             bool
+	IdleTimeoutSec   int
+	ConnectTimeoutMs int
+	ReadTimeoutMs    int
+	WriteTimeoutMs   int
+	// log connection not given back after LeakTimeoutSec, 0 disable the check
+	LeakTimeoutSec int
+	// PING connection on borrow only once it was idle this long, 0 PING on every borrow
+	TestOnBorrowAfterSec int
+	// failed call in a row opening circuit breaker, successful call closing it,
+	// and second it stay open before a call is let through
+	BreakerErrors     int
+	BreakerSuccesses  int
+	BreakerTimeoutSec int
+}
+
+// journal of write failed on secondary side, retried until applied, disabled when File is empty
+type JournalCfg struct {
+	File string
+	// longest wait between retry of an entry in second
+	MaxBackoffSec int
+}
+
+// background worker running lazy move and mirrored write
+type WorkerCfg struct {
+	Workers int
+	// task queued per worker
+	QueueSize int
+	// drop, block or spill once queue is full
+	Overflow  string
+	SpillFile string
 }
 
 type Config struct {
 	General   General
 	RedisHost RedisHostCfg
+	Pool      map[string]*PoolCfg
+	Log       LogCfg
+	Audit     AuditCfg
+	Trace     TraceCfg
+	Worker    WorkerCfg
+	Journal   JournalCfg
+	Rewrite   map[string]*RewriteCfg
+	Policy    map[string]*PolicyCfg
 }
 
 var Cfg Config
 
+// guard General options that can be changed at runtime
+var mu sync.RWMutex
+
+// options of General that can be changed while running
+var runtimeOptions = []string{"SetToDestWhenGet", "MoveHash", "MoveSet", "Duplicate", "ShadowRead"}
+
 func ReadConfig(path string) bool {
 	err := gcfg.ReadFileInto(&Cfg, path+"config.ini")
 	if err != nil {
+		logger.Debug("failed read config", "path", path, "err", err)
 		return false
 	}
-	log.Printf("%+v", Cfg)
 	if err = Cfg.Validate(); err != nil {
-		log.Println("failed read config ", err.Error())
+		logger.Error("failed read config", "path", path, "err", err)
 		return false
 	}
-	if err == nil {
-		log.Println("read config from ", path)
-		return true
-	}
-	log.Println("Error : ", err)
-	return false
+	logger.Info("read config", "path", path)
+	return true
 }
 
 func (c *Config) Validate() error {
 	//could adding validate to more config
+	for name, rw := range c.Rewrite {
+		if rw.From == "" || rw.To == "" {
+			return errors.New("rewrite " + name + " : From and To must be set")
+		}
+	}
+	switch c.General.DegradedWrite {
+	case "", "reject":
+	case "queue":
+		//queued write is kept in journal only, without it the write would be lost
+		if c.Journal.File == "" {
+			return errors.New("DegradedWrite queue needs Journal File to be set")
+		}
+	default:
+		return errors.New("DegradedWrite must be reject or queue")
+	}
+	for name := range c.Pool {
+		if name != "origin" && name != "destination" {
+			return errors.New("pool " + name + " : must be origin or destination")
+		}
+	}
+	for name, p := range c.Policy {
+		if p.Pattern == "" {
+			return errors.New("policy " + name + " : Pattern must be set")
+		}
+	}
 	return nil
 }
+
+// snapshot of General, safe to use while options changed at runtime
+func CurrentGeneral() General {
+	mu.RLock()
+	defer mu.RUnlock()
+	return Cfg.General
+}
+
+// names of options that can be changed at runtime
+func RuntimeOptions() []string {
+	names := append([]string(nil), runtimeOptions...)
+	sort.Strings(names)
+	return names
+}
+
+// get General option by name, name is case insensitive
+func GetOption(name string) (string, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	f, err := option(name)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprint(f.Interface()), nil
+}
+
+// set General option by name, only option in runtimeOptions can be changed
+func SetOption(name, value string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	f, err := option(name)
+	if err != nil {
+		return err
+	}
+	switch f.Kind() {
+	case reflect.Bool:
+		v, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		f.SetBool(v)
+	case reflect.Int, reflect.Int64:
+		v, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		f.SetInt(v)
+	case reflect.String:
+		f.SetString(value)
+	default:
+		return errors.New("option " + name + " can not be set")
+	}
+	logger.Info("config changed", "option", name, "value", value)
+	return nil
+}
+
+func option(name string) (reflect.Value, error) {
+	for _, o := range runtimeOptions {
+		if strings.EqualFold(o, name) {
+			return reflect.ValueOf(&Cfg.General).Elem().FieldByName(o), nil
+		}
+	}
+	return reflect.Value{}, errors.New("unknown runtime option " + name)
+}
@@ -0,0 +1,118 @@
+package connection
+
+import (
+	"sync"
+	"time"
+
+	"github.com/eapache/go-resiliency/breaker"
+	"github.com/garyburd/redigo/redis"
+	"github.com/tokopedia/redisgrator/logger"
+	"github.com/tokopedia/redisgrator/metrics"
+)
+
+// state of upstream breaker
+const (
+	StateClosed = "closed"
+	StateOpen   = "open"
+)
+
+var ErrUnavailable = breaker.ErrBreakerOpen
+
+// stop calling an upstream after repeated failure, a call is let through again after timeout
+type upstreamBreaker struct {
+	side    string
+	b       *breaker.Breaker
+	timeout time.Duration
+
+	mu       sync.Mutex
+	state    string
+	openedAt time.Time
+}
+
+func newBreaker(side string, errorThreshold, successThreshold int, timeout time.Duration) *upstreamBreaker {
+	ub := &upstreamBreaker{side: side, b: breaker.New(errorThreshold, successThreshold, timeout), timeout: timeout, state: StateClosed}
+	metrics.UpstreamDown.Set(side, func() float64 {
+		if ub.State() == StateOpen {
+			return 1
+		}
+		return 0
+	})
+	return ub
+}
+
+// run upstream call, only failure of upstream itself count toward opening the breaker
+func (ub *upstreamBreaker) run(call func() error) error {
+	var callErr error
+	err := ub.b.Run(func() error {
+		callErr = call()
+		if upstreamFailure(callErr) {
+			return callErr
+		}
+		return nil
+	})
+	switch {
+	case err == breaker.ErrBreakerOpen:
+		ub.set(StateOpen)
+		return err
+	case err == nil:
+		ub.set(StateClosed)
+	default:
+		//breaker only refuse the next call, see right away whether this failure opened it,
+		//no-op work is not counted while breaker is closed
+		if ub.b.Run(func() error { return nil }) == breaker.ErrBreakerOpen {
+			ub.set(StateOpen)
+		}
+	}
+	return callErr
+}
+
+func (ub *upstreamBreaker) set(state string) {
+	ub.mu.Lock()
+	if ub.state == state {
+		ub.mu.Unlock()
+		return
+	}
+	ub.state = state
+	if state == StateOpen {
+		ub.openedAt = time.Now()
+	}
+	ub.mu.Unlock()
+	metrics.BreakerTransitions.Inc(ub.side, state)
+	if state == StateOpen {
+		logger.Error("upstream unavailable, breaker opened", "side", ub.side)
+		return
+	}
+	logger.Info("upstream available again, breaker closed", "side", ub.side)
+}
+
+func (ub *upstreamBreaker) State() string {
+	ub.mu.Lock()
+	defer ub.mu.Unlock()
+	return ub.state
+}
+
+// upstream may be called, open breaker let a call through once timeout passed
+func (ub *upstreamBreaker) Healthy() bool {
+	ub.mu.Lock()
+	defer ub.mu.Unlock()
+	return ub.state == StateClosed || time.Since(ub.openedAt) >= ub.timeout
+}
+
+// reply error and missing key are answer of a healthy upstream
+func upstreamFailure(err error) bool {
+	if err == nil || err == redis.ErrNil || err == redis.ErrPoolExhausted {
+		return false
+	}
+	_, isReply := err.(redis.Error)
+	return !isReply
+}
+
+// connection failing every call, returned while upstream breaker is open
+type errorConn struct{ err error }
+
+func (c errorConn) Close() error                                   { return nil }
+func (c errorConn) Err() error                                     { return c.err }
+func (c errorConn) Do(string, ...interface{}) (interface{}, error) { return nil, c.err }
+func (c errorConn) Send(string, ...interface{}) error              { return c.err }
+func (c errorConn) Flush() error                                   { return c.err }
+func (c errorConn) Receive() (interface{}, error)                  { return nil, c.err }
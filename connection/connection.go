@@ -1,62 +1,285 @@
 package connection
 
 import (
-	"log"
 	"os"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/garyburd/redigo/redis"
+	"github.com/tokopedia/redisgrator/logger"
+	"github.com/tokopedia/redisgrator/metrics"
 )
 
 type redisPool interface {
+	// acquire connection, caller must Close it to give it back to pool
 	Get() redis.Conn
+	// run fn with connection that is given back to pool once fn returns
+	With(fn func(redis.Conn) error) error
 	Close() error
 	ActiveCount() int
+	IdleCount() int
+	// connection acquired and not closed yet
+	Borrowed() int
+	// breaker state, StateClosed or StateOpen
+	State() string
+	// upstream may be called, false while breaker is open
+	Healthy() bool
 }
 
 type RedisPoolHost struct {
 	Origin      redisPool
 	Destination redisPool
+	// batch background write, like Duplicate mirroring, to each upstream
+	OriginPipeline      *Pipeline
+	DestinationPipeline *Pipeline
 }
 
 var RedisPoolConnection *RedisPoolHost
 
+// pool and timeout of an upstream, zero timeout wait forever
+type Options struct {
+	// connection open at once, 0 is unlimited
+	MaxActive int
+	MaxIdle   int
+	// wait for connection given back once MaxActive is reached instead of failing
+	Wait           bool
+	IdleTimeout    time.Duration
+	ConnectTimeout time.Duration
+	ReadTimeout    time.Duration
+	WriteTimeout   time.Duration
+	// connection not given back after LeakTimeout is logged as leak, 0 disable the check
+	LeakTimeout time.Duration
+	// PING connection on borrow only once it was idle this long, 0 PING on every borrow
+	TestOnBorrowAfter time.Duration
+	// failed call in a row opening the breaker, successful call closing it again,
+	// and how long it stay open before a call is let through
+	BreakerErrors    int
+	BreakerSuccesses int
+	BreakerTimeout   time.Duration
+}
+
+var DefaultOptions = Options{MaxIdle: 10, IdleTimeout: 240 * time.Second,
+	BreakerErrors: 5, BreakerSuccesses: 1, BreakerTimeout: 5 * time.Second}
+
 //create new redis connection pool
-func RedisConn(orig, dest string) *RedisPoolHost {
+func RedisConn(orig, dest string, origOpt, destOpt Options, batchSize int) *RedisPoolHost {
 	var redisPoolH RedisPoolHost
 
-	poolSize := 10
+	redisPoolH.Origin = NewPool("origin", orig, origOpt)
+	redisPoolH.Destination = NewPool("destination", dest, destOpt)
 
-	redisPoolH.Origin = &redis.Pool{
-		MaxIdle:     poolSize,
-		IdleTimeout: 240 * time.Second,
-		Dial:        func() (redis.Conn, error) { return redis.Dial("tcp", orig) },
-		TestOnBorrow: func(c redis.Conn, t time.Time) error {
-			_, err := c.Do("PING")
-			return err
-		},
+	redisPoolH.OriginPipeline = NewPipeline(redisPoolH.Origin, batchSize)
+	redisPoolH.DestinationPipeline = NewPipeline(redisPoolH.Destination, batchSize)
+
+	registerPoolMetrics("origin", redisPoolH.Origin)
+	registerPoolMetrics("destination", redisPoolH.Destination)
+
+	//start degraded while one side is down, its breaker let call through once it is back
+	errOrg := redisPoolH.Origin.With(ping)
+	errDest := redisPoolH.Destination.With(ping)
+	if errOrg != nil && errDest != nil {
+		logger.Fatal("failed to connect redis origin and destination", "origin", errOrg, "destination", errDest)
+		os.Exit(0)
+	}
+	if errOrg != nil {
+		logger.Error("starting without redis origin", "err", errOrg)
 	}
+	if errDest != nil {
+		logger.Error("starting without redis destination", "err", errDest)
+	}
+
+	return &redisPoolH
+}
+
+func ping(conn redis.Conn) error {
+	_, err := conn.Do("PING")
+	return err
+}
+
+// pool returning connection that record latency of every upstream call
+type Pool struct {
+	*redis.Pool
+	side     string
+	borrowed int64
+	breaker  *upstreamBreaker
 
-	redisPoolH.Destination = &redis.Pool{
-		MaxIdle:     poolSize,
-		IdleTimeout: 240 * time.Second,
-		Dial:        func() (redis.Conn, error) { return redis.Dial("tcp", dest) },
+	leakTimeout time.Duration
+	mu          sync.Mutex
+	held        map[*timedConn]string
+}
+
+func NewPool(side, addr string, opt Options) *Pool {
+	dialOpts := []redis.DialOption{
+		redis.DialConnectTimeout(opt.ConnectTimeout),
+		redis.DialReadTimeout(opt.ReadTimeout),
+		redis.DialWriteTimeout(opt.WriteTimeout),
+	}
+	p := &Pool{side: side, leakTimeout: opt.LeakTimeout, breaker: newBreaker(side, opt.BreakerErrors, opt.BreakerSuccesses, opt.BreakerTimeout), Pool: &redis.Pool{
+		MaxActive:   opt.MaxActive,
+		MaxIdle:     opt.MaxIdle,
+		Wait:        opt.Wait,
+		IdleTimeout: opt.IdleTimeout,
+		Dial:        func() (redis.Conn, error) { return redis.Dial("tcp", addr, dialOpts...) },
 		TestOnBorrow: func(c redis.Conn, t time.Time) error {
+			//t is when connection was given back, recently used one is trusted
+			if time.Since(t) < opt.TestOnBorrowAfter {
+				return nil
+			}
 			_, err := c.Do("PING")
 			return err
 		},
+	}}
+	if p.leakTimeout > 0 {
+		p.held = make(map[*timedConn]string)
+		go p.checkLeaks()
 	}
+	return p
+}
 
-	_, errOrg := redisPoolH.Origin.Get().Do("PING")
-	if errOrg != nil {
-		log.Fatal("failed to connect redis origin : ", errOrg)
-		os.Exit(0)
+func (p *Pool) Get() redis.Conn {
+	var conn redis.Conn
+	err := p.breaker.run(func() error {
+		conn = p.Pool.Get()
+		return conn.Err()
+	})
+	if err == ErrUnavailable {
+		//fail fast instead of dialing an upstream known to be down
+		return errorConn{err}
 	}
-	_, errDest := redisPoolH.Destination.Get().Do("PING")
-	if errDest != nil {
-		log.Fatal("failed to connect redis destination : ", errDest)
-		os.Exit(0)
+	c := &timedConn{Conn: conn, pool: p, since: time.Now()}
+	atomic.AddInt64(&p.borrowed, 1)
+	if p.held != nil {
+		//remember who acquired it, reported if it is never given back
+		site := "unknown"
+		if _, file, line, ok := runtime.Caller(1); ok {
+			site = file + ":" + strconv.Itoa(line)
+		}
+		p.mu.Lock()
+		p.held[c] = site
+		p.mu.Unlock()
 	}
+	return c
+}
 
-	return &redisPoolH
+func (p *Pool) With(fn func(redis.Conn) error) error {
+	conn := p.Get()
+	defer conn.Close()
+	return fn(conn)
+}
+
+func (p *Pool) Borrowed() int {
+	return int(atomic.LoadInt64(&p.borrowed))
+}
+
+func (p *Pool) State() string {
+	return p.breaker.State()
+}
+
+func (p *Pool) Healthy() bool {
+	return p.breaker.Healthy()
+}
+
+func (p *Pool) release(c *timedConn) {
+	atomic.AddInt64(&p.borrowed, -1)
+	if p.held != nil {
+		p.mu.Lock()
+		delete(p.held, c)
+		p.mu.Unlock()
+	}
+}
+
+// log connection held longer than leak timeout, each one only once
+func (p *Pool) checkLeaks() {
+	reported := make(map[*timedConn]bool)
+	for range time.Tick(p.leakTimeout) {
+		p.mu.Lock()
+		for c, site := range p.held {
+			if reported[c] || time.Since(c.since) < p.leakTimeout {
+				continue
+			}
+			reported[c] = true
+			metrics.LeakedConnections.Inc(p.side)
+			logger.Error("connection not given back to pool", "side", p.side, "acquired", site, "held", time.Since(c.since).String())
+		}
+		for c := range reported {
+			if _, ok := p.held[c]; !ok {
+				delete(reported, c)
+			}
+		}
+		p.mu.Unlock()
+	}
+}
+
+type timedConn struct {
+	redis.Conn
+	pool   *Pool
+	since  time.Time
+	closed int32
+}
+
+func (c *timedConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	if cmd == "" {
+		//flush pending command only, nothing to measure
+		return c.Conn.Do(cmd, args...)
+	}
+	start := time.Now()
+	var v interface{}
+	err := c.pool.breaker.run(func() error {
+		var err error
+		v, err = c.Conn.Do(cmd, args...)
+		return err
+	})
+	if err == ErrUnavailable {
+		return nil, err
+	}
+	metrics.UpstreamLatency.Observe(time.Since(start).Seconds(), cmd, c.pool.side)
+	if err != nil && err != redis.ErrNil {
+		metrics.UpstreamErrors.Inc(c.pool.side)
+	}
+	return v, err
+}
+
+// pipelined call go through the breaker like Do, so upstream failing while pipelining open it too
+func (c *timedConn) Send(cmd string, args ...interface{}) error {
+	return c.pipelined(func() error { return c.Conn.Send(cmd, args...) })
+}
+
+func (c *timedConn) Flush() error {
+	return c.pipelined(c.Conn.Flush)
+}
+
+func (c *timedConn) Receive() (interface{}, error) {
+	var v interface{}
+	err := c.pipelined(func() error {
+		var err error
+		v, err = c.Conn.Receive()
+		return err
+	})
+	return v, err
+}
+
+func (c *timedConn) pipelined(call func() error) error {
+	err := c.pool.breaker.run(call)
+	if err != nil && err != ErrUnavailable && err != redis.ErrNil {
+		metrics.UpstreamErrors.Inc(c.pool.side)
+	}
+	return err
+}
+
+// give connection back to pool, closing more than once is harmless
+func (c *timedConn) Close() error {
+	if !atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
+		return nil
+	}
+	c.pool.release(c)
+	return c.Conn.Close()
+}
+
+func registerPoolMetrics(side string, pool redisPool) {
+	metrics.PoolActive.Set(side, func() float64 { return float64(pool.ActiveCount()) })
+	metrics.PoolIdle.Set(side, func() float64 { return float64(pool.IdleCount()) })
+	metrics.PoolBorrowed.Set(side, func() float64 { return float64(pool.Borrowed()) })
 }
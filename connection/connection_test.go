@@ -0,0 +1,78 @@
+package connection
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+type okConn struct{}
+
+func (okConn) Close() error                                   { return nil }
+func (okConn) Err() error                                     { return nil }
+func (okConn) Do(string, ...interface{}) (interface{}, error) { return "OK", nil }
+func (okConn) Send(string, ...interface{}) error              { return nil }
+func (okConn) Flush() error                                   { return nil }
+func (okConn) Receive() (interface{}, error)                  { return "OK", nil }
+
+func testPool(dial func() (redis.Conn, error)) *Pool {
+	p := NewPool("origin", "", Options{MaxIdle: 2, BreakerErrors: 1, BreakerSuccesses: 1, BreakerTimeout: time.Hour})
+	p.Pool.Dial = dial
+	return p
+}
+
+func TestBorrowed(t *testing.T) {
+	p := testPool(func() (redis.Conn, error) { return okConn{}, nil })
+	tests := []struct {
+		name string
+		step func(held *[]redis.Conn)
+		want int
+	}{
+		{"get", func(held *[]redis.Conn) { *held = append(*held, p.Get()) }, 1},
+		{"get again", func(held *[]redis.Conn) { *held = append(*held, p.Get()) }, 2},
+		{"close", func(held *[]redis.Conn) { (*held)[0].Close() }, 1},
+		{"close twice", func(held *[]redis.Conn) { (*held)[0].Close() }, 1},
+		{"with", func(held *[]redis.Conn) {
+			p.With(func(redis.Conn) error {
+				if p.Borrowed() != 2 {
+					t.Errorf("got %d borrowed inside With, want 2", p.Borrowed())
+				}
+				return nil
+			})
+		}, 1},
+		{"close last", func(held *[]redis.Conn) { (*held)[1].Close() }, 0},
+	}
+	var held []redis.Conn
+	for _, tt := range tests {
+		tt.step(&held)
+		if got := p.Borrowed(); got != tt.want {
+			t.Errorf("%s : got %d borrowed, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBreakerOpenNotBorrowed(t *testing.T) {
+	p := testPool(func() (redis.Conn, error) { return nil, errors.New("connection refused") })
+	conn := p.Get()
+	if conn.Err() == nil {
+		t.Fatal("got no error from failed dial")
+	}
+	conn.Close()
+	if p.Healthy() || p.State() != StateOpen {
+		t.Fatalf("got state %s after failed dial, want %s", p.State(), StateOpen)
+	}
+
+	conn = p.Get()
+	if p.Borrowed() != 0 {
+		t.Errorf("got %d borrowed while breaker open, want 0", p.Borrowed())
+	}
+	if _, err := conn.Do("PING"); err != ErrUnavailable {
+		t.Errorf("got %v while breaker open, want ErrUnavailable", err)
+	}
+	conn.Close()
+	if p.Borrowed() != 0 {
+		t.Errorf("got %d borrowed after close, want 0", p.Borrowed())
+	}
+}
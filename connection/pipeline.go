@@ -0,0 +1,128 @@
+package connection
+
+import (
+	"github.com/garyburd/redigo/redis"
+)
+
+// command sent without waiting for reply, reply read once size command are queued or on Flush
+type Batch struct {
+	conn    redis.Conn
+	size    int
+	pending int
+	err     error
+}
+
+func NewBatch(conn redis.Conn, size int) *Batch {
+	if size < 1 {
+		size = 1
+	}
+	return &Batch{conn: conn, size: size}
+}
+
+// queue command, flushing the batch once it is full
+func (b *Batch) Send(cmd string, args ...interface{}) error {
+	if err := b.conn.Send(cmd, args...); err != nil {
+		return err
+	}
+	b.pending++
+	if b.pending >= b.size {
+		return b.Flush()
+	}
+	return nil
+}
+
+// send queued command and read their reply, return first error seen by the batch
+func (b *Batch) Flush() error {
+	if b.pending == 0 {
+		return b.err
+	}
+	n := b.pending
+	b.pending = 0
+	if err := b.conn.Flush(); err != nil {
+		b.err = err
+		return err
+	}
+	for i := 0; i < n; i++ {
+		if _, err := b.conn.Receive(); err != nil && b.err == nil {
+			b.err = err
+		}
+	}
+	return b.err
+}
+
+// callback receiving reply of a pipelined command
+type Callback func(reply interface{}, err error)
+
+type call struct {
+	cmd  string
+	args []interface{}
+	done Callback
+}
+
+// send command queued by many goroutine to one upstream over a single connection,
+// up to size command per round trip, callback are run in queue order
+type Pipeline struct {
+	pool  redisPool
+	size  int
+	queue chan call
+}
+
+func NewPipeline(pool redisPool, size int) *Pipeline {
+	if size < 1 {
+		size = 1
+	}
+	p := &Pipeline{pool: pool, size: size, queue: make(chan call, size*16)}
+	go p.run()
+	return p
+}
+
+// queue command, block while queue is full
+func (p *Pipeline) Go(done Callback, cmd string, args ...interface{}) {
+	p.queue <- call{cmd: cmd, args: args, done: done}
+}
+
+func (p *Pipeline) run() {
+	for c := range p.queue {
+		calls := []call{c}
+	collect:
+		for len(calls) < p.size {
+			select {
+			case c := <-p.queue:
+				calls = append(calls, c)
+			default:
+				break collect
+			}
+		}
+		p.exec(calls)
+	}
+}
+
+func (p *Pipeline) exec(calls []call) {
+	conn := p.pool.Get()
+	defer conn.Close()
+	sent := 0
+	var err error
+	for _, c := range calls {
+		if err = conn.Send(c.cmd, c.args...); err != nil {
+			break
+		}
+		sent++
+	}
+	if err == nil {
+		err = conn.Flush()
+	}
+	for i, c := range calls {
+		if err != nil || i >= sent {
+			finish(c, nil, err)
+			continue
+		}
+		reply, rerr := conn.Receive()
+		finish(c, reply, rerr)
+	}
+}
+
+func finish(c call, reply interface{}, err error) {
+	if c.done != nil {
+		c.done(reply, err)
+	}
+}
@@ -0,0 +1,134 @@
+package connection
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/tokopedia/redisgrator/redistest"
+)
+
+// connection whose pipelined call fail like a broken socket
+type brokenConn struct {
+	okConn
+	send, flush, receive error
+}
+
+func (c brokenConn) Send(string, ...interface{}) error { return c.send }
+func (c brokenConn) Flush() error                      { return c.flush }
+func (c brokenConn) Receive() (interface{}, error)     { return nil, c.receive }
+
+var errBroken = errors.New("broken pipe")
+
+func TestBatch(t *testing.T) {
+	r := redistest.New()
+	b := NewBatch(redistest.NewConn(r), 2)
+	for i := 0; i < 5; i++ {
+		if err := b.Send("SET", "k"+strconv.Itoa(i), i); err != nil {
+			t.Fatal(err)
+		}
+		//reply is read once size command are queued
+		if want := i + 1 - (i+1)%2; len(r.Data) != want {
+			t.Errorf("after %d send : got %d key written, want %d", i+1, len(r.Data), want)
+		}
+	}
+	if err := b.Flush(); err != nil || len(r.Data) != 5 {
+		t.Fatalf("got %v with %d key written after flush, want 5", err, len(r.Data))
+	}
+
+	//first error is kept and returned by later flush
+	r.FailNext("SET", 1)
+	b = NewBatch(redistest.NewConn(r), 10)
+	b.Send("SET", "a", 1)
+	b.Send("SET", "b", 2)
+	if err := b.Flush(); err != redistest.ErrInjected {
+		t.Errorf("got %v, want error of first command", err)
+	}
+	if err := b.Flush(); err != redistest.ErrInjected {
+		t.Errorf("got %v on empty flush, want previous error", err)
+	}
+	if r.Data["b"] != "2" {
+		t.Error("command after failed one not run")
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	r := redistest.New()
+	p := testPool(redistest.Dial(r, nil))
+	pl := NewPipeline(p, 3)
+	var mu sync.Mutex
+	var order []int
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		i := i
+		pl.Go(func(reply interface{}, err error) {
+			defer wg.Done()
+			if reply != "OK" || err != nil {
+				t.Errorf("SET %d : got %v %v", i, reply, err)
+			}
+			mu.Lock()
+			order = append(order, i)
+			mu.Unlock()
+		}, "SET", "k", i)
+	}
+	wg.Add(1)
+	var got interface{}
+	pl.Go(func(reply interface{}, err error) {
+		defer wg.Done()
+		got = reply
+	}, "GET", "k")
+	wg.Wait()
+	want := []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}
+	if !reflect.DeepEqual(order, want) {
+		t.Errorf("got callback order %v, want %v", order, want)
+	}
+	if string(got.([]byte)) != "9" {
+		t.Errorf("got %q, want value of last SET", got)
+	}
+	if p.Borrowed() != 0 {
+		t.Errorf("got %d borrowed after pipeline round trip, want 0", p.Borrowed())
+	}
+}
+
+func TestPipelinedFailureOpenBreaker(t *testing.T) {
+	tests := []struct {
+		name     string
+		conn     brokenConn
+		wantOpen bool
+	}{
+		{"send", brokenConn{send: errBroken}, true},
+		{"flush", brokenConn{flush: errBroken}, true},
+		{"receive", brokenConn{receive: errBroken}, true},
+		//reply error is an answer of a healthy upstream
+		{"reply error", brokenConn{receive: redis.Error("ERR wrong type")}, false},
+	}
+	for _, tt := range tests {
+		conn := tt.conn
+		p := testPool(func() (redis.Conn, error) { return conn, nil })
+		c := p.Get()
+		if err := NewBatch(c, 1).Send("SET", "k", 1); err == nil {
+			t.Errorf("%s : got no error", tt.name)
+		}
+		c.Close()
+		if open := p.State() == StateOpen; open != tt.wantOpen {
+			t.Errorf("%s : got breaker %s", tt.name, p.State())
+		}
+	}
+
+	//pipeline callback see the failure, then fail fast while breaker is open
+	p := testPool(func() (redis.Conn, error) { return brokenConn{flush: errBroken}, nil })
+	pl := NewPipeline(p, 2)
+	errs := make(chan error, 2)
+	pl.Go(func(_ interface{}, err error) { errs <- err }, "SET", "k", 1)
+	if err := <-errs; err != errBroken {
+		t.Errorf("got %v, want flush error", err)
+	}
+	pl.Go(func(_ interface{}, err error) { errs <- err }, "SET", "k", 1)
+	if err := <-errs; err != ErrUnavailable {
+		t.Errorf("got %v once breaker open, want ErrUnavailable", err)
+	}
+}
@@ -0,0 +1,325 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tokopedia/redisgrator/audit"
+	"github.com/tokopedia/redisgrator/config"
+	"github.com/tokopedia/redisgrator/connection"
+	"github.com/tokopedia/redisgrator/metrics"
+	"github.com/tokopedia/redisgrator/phase"
+	"github.com/tokopedia/redisgrator/shadow"
+	"github.com/tokopedia/redisgrator/verify"
+)
+
+var errAdminDisabled = errors.New("REDISGRATOR : admin command disabled, AdminPassword is not set")
+var errAdminAuth = errors.New("REDISGRATOR : invalid admin password")
+
+// key compared per second by SCAN START when rate is not given
+const defaultVerifyRate = 100
+
+// REDISGRATOR AUTH <password>, then REDISGRATOR <subcommand> [args...]
+// admin command to inspect and steer migration from any redis client,
+// password is the one stored on the connection by adminConn
+func (h *RedisHandler) Redisgrator(password, sub string, args [][]byte) ([]byte, error) {
+	adminPassword := config.CurrentGeneral().AdminPassword
+	if adminPassword == "" {
+		return nil, errAdminDisabled
+	}
+	if subtle.ConstantTimeCompare([]byte(password), []byte(adminPassword)) != 1 {
+		return nil, errAdminAuth
+	}
+
+	switch strings.ToUpper(sub) {
+	case "STATUS":
+		return h.adminStatus()
+	case "PHASE":
+		return adminPhase(args)
+	case "MOVE":
+		return adminMove(args)
+	case "VERIFY":
+		return adminVerify(args)
+	case "CONFIG":
+		return adminConfig(args)
+	case "SCAN":
+		return adminScan(args)
+	case "REPAIR":
+		return adminRepair(args)
+	case "SHADOW":
+		return adminShadow(args)
+	case "AUDIT":
+		return adminAudit(args)
+	case "JOURNAL":
+		return adminJournal(args)
+	}
+	return nil, errors.New("REDISGRATOR : unknown subcommand " + sub)
+}
+
+// REDISGRATOR STATUS
+func (h *RedisHandler) adminStatus() ([]byte, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "phase:%s\r\n", phase.Current())
+	fmt.Fprintf(&b, "uptime_in_seconds:%d\r\n", int(time.Since(h.Start).Seconds()))
+	fmt.Fprintf(&b, "origin:%s\r\n", config.Cfg.RedisHost.Origin)
+	fmt.Fprintf(&b, "destination:%s\r\n", config.Cfg.RedisHost.Destination)
+	fmt.Fprintf(&b, "origin_active_connections:%d\r\n", connection.RedisPoolConnection.Origin.ActiveCount())
+	fmt.Fprintf(&b, "destination_active_connections:%d\r\n", connection.RedisPoolConnection.Destination.ActiveCount())
+	return []byte(b.String()), nil
+}
+
+// REDISGRATOR PHASE [NEXT | PREV | <phase>]
+// without argument return current phase
+func adminPhase(args [][]byte) ([]byte, error) {
+	if len(args) == 0 {
+		return []byte(phase.Current().String()), nil
+	}
+
+	var p phase.Phase
+	var err error
+	switch strings.ToUpper(string(args[0])) {
+	case "NEXT":
+		p, err = phase.Next()
+	case "PREV":
+		p, err = phase.Prev()
+	default:
+		p, err = phase.Parse(string(args[0]))
+		if err == nil {
+			err = phase.Set(p)
+		}
+	}
+	if err != nil {
+		return nil, errors.New("REDISGRATOR PHASE : " + err.Error())
+	}
+	return []byte(p.String()), nil
+}
+
+// REDISGRATOR MOVE <key>
+func adminMove(args [][]byte) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, errors.New("REDISGRATOR MOVE : wrong number of arguments")
+	}
+	typ, err := MoveKey(string(args[0]))
+	if err != nil {
+		return nil, errors.New("REDISGRATOR MOVE : " + err.Error())
+	}
+	return []byte("moved " + typ), nil
+}
+
+// REDISGRATOR VERIFY <key>
+// return ok or json description of the mismatch
+func adminVerify(args [][]byte) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, errors.New("REDISGRATOR VERIFY : wrong number of arguments")
+	}
+	m, err := verify.Key(string(args[0]))
+	if err != nil {
+		return nil, errors.New("REDISGRATOR VERIFY : " + err.Error())
+	}
+	if m == nil {
+		return []byte("ok"), nil
+	}
+	return json.Marshal(m)
+}
+
+// REDISGRATOR SCAN START [pattern] [rate] | STOP | REPORT
+// verify whole keyspace or keys matching pattern in background, at most rate key per second,
+// REPORT return summary followed by one json mismatch per line
+func adminScan(args [][]byte) ([]byte, error) {
+	if len(args) < 1 {
+		return nil, errors.New("REDISGRATOR SCAN : wrong number of arguments")
+	}
+	switch strings.ToUpper(string(args[0])) {
+	case "START":
+		if len(args) > 3 {
+			return nil, errors.New("REDISGRATOR SCAN : wrong number of arguments")
+		}
+		opts := verify.Options{Rate: defaultVerifyRate}
+		if len(args) > 1 {
+			opts.Pattern = string(args[1])
+		}
+		if len(args) > 2 {
+			rate, err := strconv.Atoi(string(args[2]))
+			if err != nil || rate <= 0 {
+				return nil, errors.New("REDISGRATOR SCAN : rate must be a positive integer")
+			}
+			opts.Rate = rate
+		}
+		if err := verify.Start(opts); err != nil {
+			return nil, errors.New("REDISGRATOR SCAN : " + err.Error())
+		}
+		return []byte("OK"), nil
+	case "STOP":
+		if err := verify.Stop(); err != nil {
+			return nil, errors.New("REDISGRATOR SCAN : " + err.Error())
+		}
+		return []byte("OK"), nil
+	case "REPORT":
+		sum, mismatches := verify.Report()
+		var b bytes.Buffer
+		enc := json.NewEncoder(&b)
+		if err := enc.Encode(sum); err != nil {
+			return nil, err
+		}
+		for _, m := range mismatches {
+			if err := enc.Encode(m); err != nil {
+				return nil, err
+			}
+		}
+		return b.Bytes(), nil
+	}
+	return nil, errors.New("REDISGRATOR SCAN : unknown subcommand " + string(args[0]))
+}
+
+// REDISGRATOR REPAIR KEY <key> [DRYRUN] | REPORT [DRYRUN]
+// repair single key or every mismatch of last SCAN report, one json result per line.
+// DRYRUN is only read after the key, so a key named DRYRUN can still be repaired
+func adminRepair(args [][]byte) ([]byte, error) {
+	if len(args) < 1 {
+		return nil, errors.New("REDISGRATOR REPAIR : wrong number of arguments")
+	}
+	// position of the optional DRYRUN
+	opt := 1
+	var keys []string
+	switch strings.ToUpper(string(args[0])) {
+	case "KEY":
+		if len(args) < 2 {
+			return nil, errors.New("REDISGRATOR REPAIR : wrong number of arguments")
+		}
+		keys = []string{string(args[1])}
+		opt = 2
+	case "REPORT":
+		sum, mismatches := verify.Report()
+		if sum.Running {
+			return nil, errors.New("REDISGRATOR REPAIR : verification is still running")
+		}
+		for _, m := range mismatches {
+			keys = append(keys, m.Key)
+		}
+	default:
+		return nil, errors.New("REDISGRATOR REPAIR : unknown subcommand " + string(args[0]))
+	}
+	dryRun := false
+	switch {
+	case len(args) == opt+1 && strings.ToUpper(string(args[opt])) == "DRYRUN":
+		dryRun = true
+	case len(args) != opt:
+		return nil, errors.New("REDISGRATOR REPAIR : wrong number of arguments")
+	}
+
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	for _, key := range keys {
+		res, err := RepairKey(key, dryRun)
+		if err != nil {
+			res.Error = err.Error()
+		}
+		if err := enc.Encode(res); err != nil {
+			return nil, err
+		}
+	}
+	return b.Bytes(), nil
+}
+
+// REDISGRATOR SHADOW [RESET]
+// return shadow read mismatch rate followed by latest mismatch sample, one json per line
+func adminShadow(args [][]byte) ([]byte, error) {
+	if len(args) == 1 && strings.ToUpper(string(args[0])) == "RESET" {
+		shadow.Reset()
+		return []byte("OK"), nil
+	}
+	if len(args) != 0 {
+		return nil, errors.New("REDISGRATOR SHADOW : wrong number of arguments")
+	}
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	err := enc.Encode(map[string]interface{}{
+		"enabled":       config.CurrentGeneral().ShadowRead,
+		"reads":         metrics.ShadowReads.Snapshot(),
+		"mismatches":    metrics.ShadowMismatches.Snapshot(),
+		"mismatch_rate": shadow.MismatchRate(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, sample := range shadow.Samples() {
+		if err := enc.Encode(sample); err != nil {
+			return nil, err
+		}
+	}
+	return b.Bytes(), nil
+}
+
+// REDISGRATOR AUDIT <key> [limit]
+// return latest audit entries of key, one json entry per line
+func adminAudit(args [][]byte) ([]byte, error) {
+	if len(args) < 1 || len(args) > 2 {
+		return nil, errors.New("REDISGRATOR AUDIT : wrong number of arguments")
+	}
+	if !audit.Enabled() {
+		return nil, errors.New("REDISGRATOR AUDIT : audit log is disabled")
+	}
+	limit := 10
+	if len(args) == 2 {
+		n, err := strconv.Atoi(string(args[1]))
+		if err != nil {
+			return nil, errors.New("REDISGRATOR AUDIT : invalid limit")
+		}
+		limit = n
+	}
+	entries, err := audit.Search(string(args[0]), limit)
+	if err != nil {
+		return nil, errors.New("REDISGRATOR AUDIT : " + err.Error())
+	}
+	var b strings.Builder
+	for _, e := range entries {
+		line, err := json.Marshal(e)
+		if err != nil {
+			return nil, errors.New("REDISGRATOR AUDIT : " + err.Error())
+		}
+		b.Write(line)
+		b.WriteString("\r\n")
+	}
+	return []byte(b.String()), nil
+}
+
+// REDISGRATOR CONFIG GET <option | *>
+// REDISGRATOR CONFIG SET <option> <value>
+func adminConfig(args [][]byte) ([]byte, error) {
+	if len(args) < 2 {
+		return nil, errors.New("REDISGRATOR CONFIG : wrong number of arguments")
+	}
+	name := string(args[1])
+	switch strings.ToUpper(string(args[0])) {
+	case "GET":
+		names := []string{name}
+		if name == "*" {
+			names = config.RuntimeOptions()
+		}
+		var b strings.Builder
+		for _, n := range names {
+			v, err := config.GetOption(n)
+			if err != nil {
+				return nil, errors.New("REDISGRATOR CONFIG : " + err.Error())
+			}
+			fmt.Fprintf(&b, "%s:%s\r\n", n, v)
+		}
+		return []byte(b.String()), nil
+	case "SET":
+		if len(args) != 3 {
+			return nil, errors.New("REDISGRATOR CONFIG : wrong number of arguments")
+		}
+		err := config.SetOption(name, string(args[2]))
+		if err != nil {
+			return nil, errors.New("REDISGRATOR CONFIG : " + err.Error())
+		}
+		return []byte("OK"), nil
+	}
+	return nil, errors.New("REDISGRATOR CONFIG : unknown subcommand " + string(args[0]))
+}
@@ -0,0 +1,144 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/tokopedia/redisgrator/config"
+	"github.com/tokopedia/redisgrator/logger"
+	"github.com/tokopedia/redisgrator/resp"
+)
+
+var errAdminNoAuth = errors.New("REDISGRATOR : authentication required, use REDISGRATOR AUTH <password>")
+
+// client bytes buffered while waiting for the rest of a command, checking is given up above it
+const maxAdminCarry = 8 * 1024 * 1024
+
+// wrap client connection so admin password is sent once with REDISGRATOR AUTH <password>
+// and kept on the connection instead of being part of every admin command
+func NewAdminConn(conn net.Conn) net.Conn {
+	return &adminConn{Conn: conn}
+}
+
+// client connection answering REDISGRATOR AUTH itself, later REDISGRATOR <subcommand> is handed
+// to the server with the password stored here, so it never travel again once authenticated.
+// like batch pipeline, only complete command is handed to the server and reply is written here
+// only once the server read again, so every reply stay in client order
+type adminConn struct {
+	net.Conn
+	// password given by the last successful REDISGRATOR AUTH
+	password string
+	// client bytes read but not parsed into complete command yet
+	carry []byte
+	// complete command waiting to be read by the server
+	out []byte
+	err error
+	// client bytes can't be parsed, everything is handed to the server as is
+	broken bool
+}
+
+func (c *adminConn) Read(b []byte) (int, error) {
+	buf := make([]byte, 16*1024)
+	for {
+		if len(c.out) > 0 {
+			n := copy(b, c.out)
+			c.out = c.out[n:]
+			return n, nil
+		}
+		if c.broken {
+			if c.err != nil {
+				return 0, c.err
+			}
+			return c.Conn.Read(b)
+		}
+		c.dispatch()
+		if len(c.out) > 0 || c.broken {
+			continue
+		}
+		if c.err != nil {
+			if len(c.carry) > 0 {
+				//incomplete trailing command, let the server deal with it
+				c.out, c.carry = c.carry, nil
+				continue
+			}
+			return 0, c.err
+		}
+		n, err := c.Conn.Read(buf)
+		c.carry = append(c.carry, buf[:n]...)
+		c.err = err
+	}
+}
+
+// move complete command to out up to the next admin command, which is handled
+// once everything before it has been read by the server
+func (c *adminConn) dispatch() {
+	for len(c.carry) > 0 {
+		args, size, err := resp.ParseCommand(c.carry)
+		if err == resp.ErrIncomplete {
+			if len(c.carry) > maxAdminCarry {
+				c.giveUp()
+			}
+			return
+		}
+		if err != nil {
+			logger.Debug("admin : stop checking on unparsable client input", "err", err)
+			c.giveUp()
+			return
+		}
+		if len(args) < 2 || !strings.EqualFold(args[0], "REDISGRATOR") {
+			c.out = append(c.out, c.carry[:size]...)
+			c.carry = c.carry[size:]
+			continue
+		}
+		if len(c.out) > 0 {
+			return
+		}
+		c.carry = c.carry[size:]
+		if strings.EqualFold(args[1], "AUTH") {
+			c.auth(args[2:])
+			continue
+		}
+		if c.password == "" {
+			c.reply(resp.AppendError(nil, errAdminNoAuth))
+			continue
+		}
+		c.out = resp.AppendCommand(nil, append([]string{args[0], c.password}, args[1:]...)...)
+		return
+	}
+}
+
+// REDISGRATOR AUTH <password>
+func (c *adminConn) auth(args []string) {
+	adminPassword := config.CurrentGeneral().AdminPassword
+	var err error
+	switch {
+	case len(args) != 1:
+		err = errors.New("REDISGRATOR AUTH : wrong number of arguments")
+	case adminPassword == "":
+		err = errAdminDisabled
+	case subtle.ConstantTimeCompare([]byte(args[0]), []byte(adminPassword)) != 1:
+		//failed attempt drop previous authentication like redis AUTH
+		c.password = ""
+		err = errAdminAuth
+	}
+	if err != nil {
+		c.reply(resp.AppendError(nil, err))
+		return
+	}
+	c.password = args[0]
+	c.reply([]byte("+OK\r\n"))
+}
+
+func (c *adminConn) reply(b []byte) {
+	if _, err := c.Conn.Write(b); err != nil {
+		c.err = err
+	}
+}
+
+func (c *adminConn) giveUp() {
+	c.broken = true
+	c.out = append(c.out, c.carry...)
+	c.carry = nil
+}
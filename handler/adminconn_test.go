@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/tokopedia/redisgrator/config"
+	"github.com/tokopedia/redisgrator/resp"
+)
+
+// client sending scripted chunks, it waits for every reply before running out of input
+type scriptClient struct {
+	net.Conn
+	t       *testing.T
+	chunks  []string
+	want    string
+	written []byte
+}
+
+func (c *scriptClient) Read(b []byte) (int, error) {
+	if len(c.chunks) == 0 {
+		if len(c.written) < len(c.want) {
+			c.t.Fatalf("read client socket with reply pending, got %q so far, want %q", c.written, c.want)
+		}
+		return 0, io.EOF
+	}
+	n := copy(b, c.chunks[0])
+	if c.chunks[0] = c.chunks[0][n:]; c.chunks[0] == "" {
+		c.chunks = c.chunks[1:]
+	}
+	return n, nil
+}
+
+func (c *scriptClient) Write(b []byte) (int, error) {
+	c.written = append(c.written, b...)
+	return len(b), nil
+}
+
+// server processing one command at a time, reply to "CMD a b" is "+CMD a b"
+func serveScript(c net.Conn) []byte {
+	var data []byte
+	buf := make([]byte, 64)
+	for {
+		n, err := c.Read(buf)
+		data = append(data, buf[:n]...)
+		for {
+			args, size, perr := resp.ParseCommand(data)
+			if perr != nil {
+				break
+			}
+			c.Write([]byte("+" + strings.Join(args, " ") + "\r\n"))
+			data = data[size:]
+		}
+		if err != nil {
+			return data
+		}
+	}
+}
+
+func TestAdminConn(t *testing.T) {
+	noAuth := "-ERROR " + errAdminNoAuth.Error() + "\r\n"
+	badAuth := "-ERROR " + errAdminAuth.Error() + "\r\n"
+	tests := []struct {
+		name     string
+		password string
+		chunks   []string
+		want     string
+	}{
+		{"subcommand before auth", "secret", []string{"REDISGRATOR STATUS\r\nGET k\r\n"},
+			noAuth + "+GET k\r\n"},
+		//password is only added by the connection once authenticated
+		{"auth then subcommand", "secret", []string{"SET k v\r\nREDISGRATOR AUTH secret\r\nredisgrator phase next\r\nGET k\r\n"},
+			"+SET k v\r\n+OK\r\n+redisgrator secret phase next\r\n+GET k\r\n"},
+		{"wrong password", "secret", []string{"REDISGRATOR AUTH nope\r\nREDISGRATOR STATUS\r\n"},
+			badAuth + noAuth},
+		{"failed auth drop previous one", "secret", []string{"REDISGRATOR AUTH secret\r\nREDISGRATOR AUTH nope\r\nREDISGRATOR STATUS\r\n"},
+			"+OK\r\n" + badAuth + noAuth},
+		{"password in subcommand is not accepted", "secret", []string{"REDISGRATOR secret STATUS\r\n"},
+			noAuth},
+		{"admin disabled", "", []string{"REDISGRATOR AUTH secret\r\n"},
+			"-ERROR " + errAdminDisabled.Error() + "\r\n"},
+		{"auth arguments", "secret", []string{"REDISGRATOR AUTH\r\nREDISGRATOR AUTH a b\r\n"},
+			"-ERROR REDISGRATOR AUTH : wrong number of arguments\r\n-ERROR REDISGRATOR AUTH : wrong number of arguments\r\n"},
+		{"auth split across reads", "secret", []string{"*3\r\n$11\r\nREDISGRATOR\r\n$4\r\nAUTH\r\n$6\r\nsec", "ret\r\nREDISGRATOR SHADOW\r\n"},
+			"+OK\r\n+REDISGRATOR secret SHADOW\r\n"},
+		{"unparsable input handed as is", "secret", []string{"GET k\r\n*-1\r\nREDISGRATOR AUTH secret\r\n"},
+			"+GET k\r\n"},
+	}
+	defer func(g config.General) { config.Cfg.General = g }(config.Cfg.General)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.Cfg.General.AdminPassword = tt.password
+			client := &scriptClient{t: t, chunks: tt.chunks, want: tt.want}
+			serveScript(NewAdminConn(client))
+			if string(client.written) != tt.want {
+				t.Errorf("got reply %q, want %q", client.written, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package handler
+
+import (
+	rds "github.com/garyburd/redigo/redis"
+	"github.com/tokopedia/redisgrator/audit"
+)
+
+// ttl of key in seconds, only asked to upstream when audit is enabled
+func auditTTL(conn rds.Conn, key string) int64 {
+	if !audit.Enabled() {
+		return 0
+	}
+	ttl, err := rds.Int64(conn.Do("TTL", key))
+	if err != nil {
+		return 0
+	}
+	return ttl
+}
+
+// record action done on key to audit log, err is the outcome
+func auditRecord(action, typ, key, side string, size int, ttl int64, err error) {
+	if !audit.Enabled() {
+		return
+	}
+	outcome := "ok"
+	if err != nil {
+		outcome = err.Error()
+	}
+	audit.Record(audit.Entry{
+		Action:  action,
+		Key:     key,
+		Type:    typ,
+		Size:    size,
+		TTL:     ttl,
+		Side:    side,
+		Outcome: outcome,
+	})
+}
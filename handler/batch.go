@@ -0,0 +1,120 @@
+package handler
+
+import (
+	"sync"
+	"time"
+
+	rds "github.com/garyburd/redigo/redis"
+	"github.com/tokopedia/redisgrator/connection"
+	"github.com/tokopedia/redisgrator/logger"
+	"github.com/tokopedia/redisgrator/metrics"
+	"github.com/tokopedia/redisgrator/phase"
+	"github.com/tokopedia/redisgrator/rule"
+)
+
+// GET of a client pipeline answered together, every upstream is read with one
+// pipelined round trip for the whole batch instead of one round trip per key
+func (h *RedisHandler) GetBatch(keys []string) ([][]byte, []error) {
+	vals := make([][]byte, len(keys))
+	errs := make([]error, len(keys))
+	if err := h.acquire(); err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		return vals, errs
+	}
+	defer h.Sema.Release()
+	start := time.Now()
+	sp := startSpan("GET", "")
+	sp.SetAttr("redisgrator.batch", len(keys))
+	defer sp.End()
+	metrics.PipelineBatches.Inc()
+	metrics.PipelinedGets.Add(int64(len(keys)))
+
+	p := phase.Current()
+	pols := make([]rule.Policy, len(keys))
+	// index of each key in the batch sent to origin and destination, -1 when not read there
+	origAt := make([]int, len(keys))
+	destAt := make([]int, len(keys))
+	var origKeys, destKeys []string
+	for i, key := range keys {
+		logger.Command("GET", key)
+		pols[i] = rule.For(key)
+		origAt[i], destAt[i] = -1, -1
+		if !pols[i].Migrate || p != phase.DestOnly {
+			origAt[i] = len(origKeys)
+			origKeys = append(origKeys, key)
+		}
+		if pols[i].Migrate {
+			destAt[i] = len(destKeys)
+			destKeys = append(destKeys, rule.DestKey(key))
+		}
+	}
+
+	var origVals, destVals []interface{}
+	var origErrs, destErrs []error
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		origVals, origErrs = pipelinedGet(connection.RedisPoolConnection.Origin, origKeys)
+	}()
+	go func() {
+		defer wg.Done()
+		destVals, destErrs = pipelinedGet(connection.RedisPoolConnection.Destination, destKeys)
+	}()
+	wg.Wait()
+
+	for i, key := range keys {
+		switch {
+		case destAt[i] < 0:
+			vals[i], errs[i] = bytesReply(origVals[origAt[i]], origErrs[origAt[i]])
+		case origAt[i] < 0:
+			vals[i], errs[i] = bytesReply(destVals[destAt[i]], destErrs[destAt[i]])
+		default:
+			valSrc, valDst := origVals[origAt[i]], destVals[destAt[i]]
+			if p == phase.Rollback {
+				valSrc, valDst = valDst, valSrc
+			}
+			vals[i], errs[i] = getReply(sp, p, pols[i], key, valSrc, valDst)
+		}
+		track("GET", key, start)
+	}
+	return vals, errs
+}
+
+// GET every key in one round trip, reply is nil for missing key or on error like getUsingChan
+func pipelinedGet(pool interface{ Get() rds.Conn }, keys []string) ([]interface{}, []error) {
+	res := make([]interface{}, len(keys))
+	errs := make([]error, len(keys))
+	if len(keys) == 0 {
+		return res, errs
+	}
+	conn := pool.Get()
+	defer conn.Close()
+	for _, key := range keys {
+		conn.Send("GET", key)
+	}
+	if err := conn.Flush(); err != nil {
+		logger.Error("GET : err when flush pipeline", "err", err)
+		for i := range errs {
+			errs[i] = err
+		}
+		return res, errs
+	}
+	for i := range keys {
+		res[i], errs[i] = conn.Receive()
+		if errs[i] != nil {
+			logger.Error("GET", "err", errs[i])
+		}
+	}
+	return res, errs
+}
+
+func bytesReply(v interface{}, err error) ([]byte, error) {
+	b, err := rds.Bytes(v, err)
+	if err == rds.ErrNil {
+		return nil, nil
+	}
+	return b, err
+}
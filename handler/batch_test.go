@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/tokopedia/redisgrator/config"
+	"github.com/tokopedia/redisgrator/phase"
+	"github.com/tokopedia/redisgrator/rule"
+)
+
+func TestGetBatchPhase(t *testing.T) {
+	pols, err := rule.NewPolicies(map[string]*config.PolicyCfg{"keep": {Pattern: "keep:*", Migrate: config.OptBool{Set: true}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rw, err := rule.NewRewriter(map[string]*config.RewriteCfg{"user": {From: "u:*", To: "d:*"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rule.KeyPolicy, rule.KeyRewriter = pols, rw
+	defer func() { rule.KeyPolicy, rule.KeyRewriter = nil, nil }()
+
+	keys := []string{"both", "oonly", "donly", "keep:1", "u:1", "none"}
+	tests := []struct {
+		phase phase.Phase
+		want  []string
+	}{
+		//origin is authoritative
+		{phase.Shadow, []string{"o", "o", "", "o", "o", ""}},
+		{phase.DualWrite, []string{"o", "o", "", "o", "o", ""}},
+		//destination first, origin for key not moved yet, non migrated key stay in origin
+		{phase.DestPrimary, []string{"d", "o", "d", "o", "d", ""}},
+		{phase.DestOnly, []string{"d", "", "d", "o", "d", ""}},
+		//origin is target again, destination for key not moved back yet
+		{phase.Rollback, []string{"o", "o", "d", "o", "o", ""}},
+	}
+	for _, tt := range tests {
+		f := newFixture(t, tt.phase, false)
+		//no copy on read so every GET see the same data
+		config.Cfg.General.SetToDestWhenGet = false
+		for k, v := range map[string]string{"both": "o", "oonly": "o", "keep:1": "o", "u:1": "o"} {
+			f.orig.Data[k] = v
+		}
+		for k, v := range map[string]string{"both": "d", "donly": "d", "keep:1": "d", "d:1": "d"} {
+			f.dest.Data[k] = v
+		}
+		vals, errs := f.h.GetBatch(keys)
+		for i, key := range keys {
+			if errs[i] != nil {
+				t.Errorf("%s GET %s : %v", tt.phase, key, errs[i])
+				continue
+			}
+			if string(vals[i]) != tt.want[i] {
+				t.Errorf("%s GET %s : got %q, want %q", tt.phase, key, vals[i], tt.want[i])
+			}
+			//batch answer like GET one key at a time
+			if v, _ := f.h.Get(key); !bytes.Equal(v, vals[i]) {
+				t.Errorf("%s GET %s : got %q from batch, %q from GET", tt.phase, key, vals[i], v)
+			}
+		}
+		f.checkBorrowed(t, tt.phase.String()+" GET batch")
+	}
+}
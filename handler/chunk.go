@@ -0,0 +1,160 @@
+package handler
+
+import (
+	"errors"
+	"sync"
+
+	rds "github.com/garyburd/redigo/redis"
+	"github.com/tokopedia/redisgrator/audit"
+	"github.com/tokopedia/redisgrator/config"
+	"github.com/tokopedia/redisgrator/connection"
+	"github.com/tokopedia/redisgrator/logger"
+	"github.com/tokopedia/redisgrator/metrics"
+	"github.com/tokopedia/redisgrator/phase"
+	"github.com/tokopedia/redisgrator/trace"
+)
+
+// element per SCAN and write when ChunkSize is not set
+const defaultChunkSize = 1000
+
+// redis command used to move each collection type
+type collection struct {
+	lenCmd   string
+	readCmd  string
+	readArgs []interface{}
+	scanCmd  string
+	writeCmd string
+	// reply element per collection element, field value or member score pair count 2
+	step int
+}
+
+var collections = map[string]collection{
+	"hash": {lenCmd: "HLEN", readCmd: "HGETALL", scanCmd: "HSCAN", writeCmd: "HMSET", step: 2},
+	"set":  {lenCmd: "SCARD", readCmd: "SMEMBERS", scanCmd: "SSCAN", writeCmd: "SADD", step: 1},
+	"zset": {lenCmd: "ZCARD", readCmd: "ZRANGE", readArgs: []interface{}{0, -1, "WITHSCORES"}, scanCmd: "ZSCAN", writeCmd: "ZADD", step: 2},
+}
+
+// key with chunked move in progress, reader merge both side meanwhile
+var chunking sync.Map
+
+func chunkInProgress(key string) bool {
+	_, ok := chunking.Load(key)
+	return ok
+}
+
+// turn read or scan reply into write argument, ZADD take score before member
+func writeArgs(typ string, vals []interface{}) []interface{} {
+	if typ != "zset" {
+		return vals
+	}
+	args := make([]interface{}, len(vals))
+	for i := 0; i+1 < len(vals); i += 2 {
+		args[i], args[i+1] = vals[i+1], vals[i]
+	}
+	return args
+}
+
+// move big key chunk by chunk with SCAN so neither upstream nor proxy handle whole value at once,
+// chunk write is pipelined to target and source is deleted once every chunk is confirmed.
+// key lock of the caller is held until the last chunk, field written to target between two
+// chunks would be overwritten by the older source value of a later chunk. writer of the key
+// wait for the whole move meanwhile, reader take no lock and merge both side with mergeReply
+func transferChunked(sp *trace.Span, p phase.Phase, srcConn rds.Conn, srcKey string, dstConn rds.Conn, dstKey string,
+	typ, key string, delSrc, replace bool) error {
+	chunking.Store(key, true)
+	defer chunking.Delete(key)
+	c := collections[typ]
+	chunk := config.CurrentGeneral().ChunkSize
+	if chunk <= 0 {
+		chunk = defaultChunkSize
+	}
+	sp.SetAttr("redisgrator.chunked", true)
+
+	pttl, err := rds.Int64(srcConn.Do("PTTL", srcKey))
+	if err != nil {
+		return errors.New("PTTL : " + err.Error())
+	}
+	ttl := pttl
+	if pttl > 0 {
+		ttl = pttl / 1000
+	}
+	if replace {
+		if _, err := dstConn.Do("DEL", dstKey); err != nil {
+			return errors.New("DEL : " + err.Error())
+		}
+	}
+
+	batch := connection.NewBatch(dstConn, config.CurrentGeneral().PipelineBatch)
+	size := 0
+	cursor := "0"
+	for {
+		reply, err := rds.Values(srcConn.Do(c.scanCmd, srcKey, cursor, "COUNT", chunk))
+		if err == nil && len(reply) != 2 {
+			err = errors.New("unexpected reply")
+		}
+		if err == nil {
+			cursor, err = rds.String(reply[0], nil)
+		}
+		if err != nil {
+			auditRecord(audit.ActionMove, typ, key, sideName(p, false), size, ttl, err)
+			return errors.New(c.scanCmd + " : " + err.Error())
+		}
+		vals, _ := reply[1].([]interface{})
+		if len(vals) > 0 {
+			err = batch.Send(c.writeCmd, append([]interface{}{dstKey}, writeArgs(typ, vals)...)...)
+			size += len(vals) / c.step
+		}
+		if err == nil && cursor == "0" {
+			err = batch.Flush()
+		}
+		if err != nil {
+			auditRecord(audit.ActionMove, typ, key, sideName(p, false), size, ttl, err)
+			return errors.New(c.writeCmd + " : " + err.Error())
+		}
+		if cursor == "0" {
+			break
+		}
+	}
+	if pttl > 0 {
+		if _, err := dstConn.Do("PEXPIRE", dstKey, pttl); err != nil {
+			return errors.New("PEXPIRE : " + err.Error())
+		}
+	}
+	auditRecord(audit.ActionMove, typ, key, sideName(p, false), size, ttl, nil)
+	metrics.Moved.Inc(typ)
+	metrics.ChunkedMoves.Inc(typ)
+	logger.Debug("chunked move "+typ, "key", logger.Key(dstKey), "size", size)
+
+	if delSrc {
+		//UNLINK free big value in background, not available before redis 4
+		_, err = srcConn.Do("UNLINK", srcKey)
+		if err != nil {
+			_, err = srcConn.Do("DEL", srcKey)
+		}
+		auditRecord(audit.ActionDelete, typ, key, sideName(p, true), size, ttl, err)
+		if err != nil {
+			return errors.New("DEL : " + err.Error())
+		}
+	}
+	return nil
+}
+
+// merge HGETALL or SMEMBERS reply of both side while key is half moved, target field win
+func mergeReply(typ string, src, dst interface{}) []interface{} {
+	srcArr, _ := src.([]interface{})
+	dstArr, _ := dst.([]interface{})
+	step := collections[typ].step
+	seen := make(map[string]bool, len(dstArr)/step)
+	res := append([]interface{}(nil), dstArr...)
+	for i := 0; i+step <= len(dstArr); i += step {
+		b, _ := dstArr[i].([]byte)
+		seen[string(b)] = true
+	}
+	for i := 0; i+step <= len(srcArr); i += step {
+		b, _ := srcArr[i].([]byte)
+		if !seen[string(b)] {
+			res = append(res, srcArr[i:i+step]...)
+		}
+	}
+	return res
+}
@@ -0,0 +1,158 @@
+package handler
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/tokopedia/redisgrator/config"
+	"github.com/tokopedia/redisgrator/phase"
+)
+
+// command of log starting with one of prefix
+func calls(log []string, prefix ...string) []string {
+	var res []string
+	for _, c := range log {
+		for _, p := range prefix {
+			if strings.HasPrefix(c, p) {
+				res = append(res, c)
+				break
+			}
+		}
+	}
+	return res
+}
+
+func chunkFixture(t *testing.T, threshold int) *fixture {
+	f := newFixture(t, phase.DestPrimary, false)
+	config.Cfg.General.BigKeyThreshold = threshold
+	config.Cfg.General.ChunkSize = 10
+	config.Cfg.General.PipelineBatch = 2
+	return f
+}
+
+func TestTransferChunkedCursor(t *testing.T) {
+	f := chunkFixture(t, 5)
+	big := map[string]string{}
+	for i := 0; i < 25; i++ {
+		big["f"+strconv.Itoa(i)] = strconv.Itoa(i)
+	}
+	f.orig.Data["big"] = big
+	f.orig.TTL["big"] = 100
+	f.orig.Calls()
+	f.dest.Calls()
+
+	if err := transferHash(nil, phase.DestPrimary, "big", true); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"HSCAN big 0 COUNT 10", "HSCAN big 10 COUNT 10", "HSCAN big 20 COUNT 10"}
+	if got := calls(f.orig.Calls(), "HSCAN", "UNLINK"); !reflect.DeepEqual(got, append(want, "UNLINK big")) {
+		t.Errorf("got source calls %q, want %q then UNLINK", got, want)
+	}
+	if got := calls(f.dest.Calls(), "HMSET", "PEXPIRE"); len(got) != 4 || got[3] != "PEXPIRE big 100000" {
+		t.Errorf("got target calls %q, want 3 HMSET then PEXPIRE", got)
+	}
+	if got := f.dest.Data["big"]; !reflect.DeepEqual(got, big) || f.dest.TTL["big"] != 100 {
+		t.Errorf("got %v ttl %d on target, want every field with ttl 100", got, f.dest.TTL["big"])
+	}
+	if _, ok := f.orig.Data["big"]; ok {
+		t.Error("source not deleted once every chunk written")
+	}
+	if chunkInProgress("big") {
+		t.Error("key still marked as chunking after move")
+	}
+	f.checkBorrowed(t, "chunked move")
+}
+
+func TestTransferZsetArgs(t *testing.T) {
+	//whole ZRANGE then chunked ZSCAN, both reply member before score
+	for _, threshold := range []int{0, 1} {
+		f := chunkFixture(t, threshold)
+		zset := map[string]float64{"a": 1, "b": 2.5}
+		f.orig.Data["z"] = zset
+		f.dest.Calls()
+		if err := transferZset(nil, phase.DestPrimary, "z", false); err != nil {
+			t.Fatal(err)
+		}
+		if got := calls(f.dest.Calls(), "ZADD"); !reflect.DeepEqual(got, []string{"ZADD z 1 a 2.5 b"}) {
+			t.Errorf("threshold %d : got %q, want score before member", threshold, got)
+		}
+		if got := f.dest.Data["z"]; !reflect.DeepEqual(got, zset) {
+			t.Errorf("threshold %d : got %v on target, want %v", threshold, got, zset)
+		}
+	}
+}
+
+func TestTransferChunkedUnlinkFallback(t *testing.T) {
+	f := chunkFixture(t, 1)
+	f.orig.FailNext("UNLINK", -1)
+	f.orig.Calls()
+	if err := transferSet(nil, phase.DestPrimary, "set", true); err != nil {
+		t.Fatal(err)
+	}
+	//redis before 4 has no UNLINK
+	if got := calls(f.orig.Calls(), "UNLINK", "DEL"); !reflect.DeepEqual(got, []string{"UNLINK set", "DEL set"}) {
+		t.Errorf("got %q, want UNLINK then DEL", got)
+	}
+	if _, ok := f.orig.Data["set"]; ok {
+		t.Error("source not deleted by DEL fallback")
+	}
+	if got := f.dest.Data["set"]; !reflect.DeepEqual(got, map[string]bool{"m1": true, "m2": true}) {
+		t.Errorf("got %v on target", got)
+	}
+}
+
+func TestReadWhileChunking(t *testing.T) {
+	f := newFixture(t, phase.DestPrimary, false)
+	f.dest.Data["hash"] = map[string]string{"f1": "new"}
+	f.dest.Data["set"] = map[string]bool{"m3": true}
+	chunking.Store("hash", true)
+	chunking.Store("set", true)
+	defer chunking.Delete("hash")
+	defer chunking.Delete("set")
+
+	//field already moved win over source
+	got, err := f.h.Hgetall("hash")
+	want := []interface{}{[]byte("f1"), []byte("new"), []byte("f2"), []byte("b")}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("HGETALL : got %q %v, want %q", got, err, want)
+	}
+	got, err = f.h.Smembers("set")
+	want = []interface{}{[]byte("m3"), []byte("m1"), []byte("m2")}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("SMEMBERS : got %q %v, want %q", got, err, want)
+	}
+	f.checkBorrowed(t, "read while chunking")
+}
+
+func TestMergeReply(t *testing.T) {
+	b := func(s ...string) []interface{} {
+		res := []interface{}{}
+		for _, v := range s {
+			res = append(res, []byte(v))
+		}
+		return res
+	}
+	tests := []struct {
+		name     string
+		typ      string
+		src, dst interface{}
+		want     []interface{}
+	}{
+		{"hash target field win", "hash", b("f1", "old", "f2", "b"), b("f1", "new"), b("f1", "new", "f2", "b")},
+		{"hash source gone", "hash", nil, b("f1", "a"), b("f1", "a")},
+		{"hash nothing moved yet", "hash", b("f1", "a"), nil, b("f1", "a")},
+		{"set union", "set", b("m1", "m2"), b("m2", "m3"), b("m2", "m3", "m1")},
+		{"both empty", "set", nil, nil, []interface{}{}},
+	}
+	for _, tt := range tests {
+		got := mergeReply(tt.typ, tt.src, tt.dst)
+		if len(got) == 0 && len(tt.want) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s : got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package handler
+
+import (
+	"errors"
+
+	"github.com/tokopedia/redisgrator/audit"
+	"github.com/tokopedia/redisgrator/config"
+	"github.com/tokopedia/redisgrator/connection"
+	"github.com/tokopedia/redisgrator/phase"
+)
+
+// DegradedWrite option
+const (
+	degradedReject = "reject"
+	degradedQueue  = "queue"
+)
+
+// false while breaker of side is open, read then answer from the other side
+// even when origin is authoritative
+func healthy(side string) bool {
+	if side == "destination" {
+		return connection.RedisPoolConnection.Destination.Healthy()
+	}
+	return connection.RedisPoolConnection.Origin.Healthy()
+}
+
+// side holding the truth in p and the side only kept in sync with it
+func primarySide(p phase.Phase) (primary, secondary string) {
+	if p.OriginPrimary() || p == phase.Rollback {
+		return "origin", "destination"
+	}
+	return "destination", "origin"
+}
+
+// reject write while an upstream it need is down, write of secondary side is
+// journaled instead when DegradedWrite is queue
+func checkWrite(cmd string, p phase.Phase) error {
+	primary, secondary := primarySide(p)
+	if !healthy(primary) {
+		return errors.New(cmd + " : " + primary + " is unavailable")
+	}
+	if !healthy(secondary) && !queueDegraded() {
+		return errors.New(cmd + " : " + secondary + " is unavailable")
+	}
+	return nil
+}
+
+func queueDegraded() bool {
+	return degradedWrite() == degradedQueue
+}
+
+func degradedWrite() string {
+	if w := config.CurrentGeneral().DegradedWrite; w != "" {
+		return w
+	}
+	return degradedReject
+}
+
+// journal write missed by secondary side while it is down, srcKey and dstKey
+// is key name on source and target side of p
+func journalDown(p phase.Phase, action, key, cmd, srcKey, dstKey string, args ...interface{}) {
+	_, secondary := primarySide(p)
+	if healthy(secondary) {
+		return
+	}
+	name := dstKey
+	if secondary == sideName(p, true) {
+		name = srcKey
+	}
+	journalWrite(action, secondary, key, cmd, append([]interface{}{name}, args...), connection.ErrUnavailable)
+}
+
+// with source side of p down key can not be moved to target before a write to it, the move is
+// journaled instead and replayed once source is back, keeping what was written to target meanwhile
+func sourceDown(p phase.Phase, key, cmd, dstKey string) bool {
+	if healthy(sideName(p, true)) {
+		return false
+	}
+	journalWrite(audit.ActionMove, sideName(p, false), key, cmd, []interface{}{dstKey}, connection.ErrUnavailable)
+	return true
+}
@@ -2,15 +2,21 @@ package handler
 
 import (
 	"errors"
-	"fmt"
-	"log"
+	"strconv"
 	"time"
 
 	"github.com/eapache/go-resiliency/semaphore"
 	rds "github.com/garyburd/redigo/redis"
 	redis "github.com/tokopedia/go-redis-server"
-	"github.com/tokopedia/redisgrator/config"
+	"github.com/tokopedia/redisgrator/audit"
 	"github.com/tokopedia/redisgrator/connection"
+	"github.com/tokopedia/redisgrator/logger"
+	"github.com/tokopedia/redisgrator/metrics"
+	"github.com/tokopedia/redisgrator/phase"
+	"github.com/tokopedia/redisgrator/rule"
+	"github.com/tokopedia/redisgrator/slowlog"
+	"github.com/tokopedia/redisgrator/trace"
+	"github.com/tokopedia/redisgrator/worker"
 )
 
 type RedisHandler struct {
@@ -21,53 +27,69 @@ type RedisHandler struct {
 
 // GET 2 side
 func (h *RedisHandler) Get(key string) ([]byte, error) {
-	err := h.Sema.Acquire()
+	err := h.acquire()
 	if err != nil {
 		return nil, err
 	}
 	defer h.Sema.Release()
-	log.Println("GET", key)
+	logger.Command("GET", key)
+	defer track("GET", key, time.Now())
+	sp := startSpan("GET", key)
+	defer sp.End()
+	dkey := rule.DestKey(key)
+	pol := rule.For(key)
+	if !pol.Migrate {
+		v, err := rds.Bytes(originDo(sp, "GET", key))
+		if err == rds.ErrNil {
+			return nil, nil
+		}
+		return v, err
+	}
+	p := phase.Current()
+	if p == phase.DestOnly {
+		v, err := rds.Bytes(destDo(sp, "GET", dkey))
+		if err == rds.ErrNil {
+			return nil, nil
+		}
+		return v, err
+	}
 
-	origConn := connection.RedisPoolConnection.Origin.Get()
-	destConn := connection.RedisPoolConnection.Destination.Get()
+	srcConn, srcKey, dstConn, dstKey := route(sp, p, key, dkey)
+	defer srcConn.Close()
+	defer dstConn.Close()
 
-	chOrig := make(chan interface{})
-	chDest := make(chan interface{})
+	chSrc := make(chan interface{})
+	chDst := make(chan interface{})
 
-	go getUsingChan(origConn, chOrig, key)
-	go getUsingChan(destConn, chDest, key)
+	go getUsingChan(srcConn, chSrc, srcKey)
+	go getUsingChan(dstConn, chDst, dstKey)
 
 	// wait completion.
-	valOrig := <-chOrig
-	valDest := <-chDest
+	valSrc := <-chSrc
+	valDst := <-chDst
+	return getReply(sp, p, pol, key, valSrc, valDst)
+}
 
+// answer GET from reply of both side, key found only in source is moved
+func getReply(sp *trace.Span, p phase.Phase, pol rule.Policy, key string, valSrc, valDst interface{}) ([]byte, error) {
 	// default exist value
-	valExist := valDest
-
-	if valDest == nil {
-		if valOrig != nil {
-			if config.Cfg.General.Duplicate {
-				go func(skey string, svalOrig interface{}) {
-					//if keys exist in origin move it too destination
-					_, err := destConn.Do("SET", skey, svalOrig.([]byte))
-					if err != nil {
-						log.Println("SET : " + err.Error())
-					}
-					return
-				}(key, valOrig)
-			}
-			if config.Cfg.General.SetToDestWhenGet && !config.Cfg.General.Duplicate {
-				go func(skey string) {
-					_, err := origConn.Do("DEL", skey)
-					if err != nil {
-						log.Println("DEL : " + err.Error())
-					}
-					return
-				}(key)
-			}
+	valExist := valDst
+	fromSrc := false
+
+	if valDst == nil {
+		if valSrc != nil && (pol.Duplicate || pol.SetToDestWhenGet) {
+			//if keys exist in source move it too target
+			submit(worker.Task{Kind: taskCopy, Key: key, Args: [][]byte{valSrc.([]byte)}, Dedup: true, Span: sp})
+			waitRoom(key)
 		}
-		valExist = valOrig // set exist value
+		valExist, fromSrc = valSrc, true // set exist value
+	}
+	if p.OriginPrimary() && healthy("origin") {
+		valExist, fromSrc = valSrc, true // origin is authoritative
+		shadowRead("GET", key, valSrc, valDst)
 	}
+	metrics.Hits.Inc(sideName(p, fromSrc))
+	sp.SetAttr("redisgrator.side", sideName(p, fromSrc))
 
 	strv, ok := valExist.([]byte)
 	if ok == false {
@@ -86,7 +108,7 @@ func getUsingChan(rcon rds.Conn, ch chan<- interface{}, key string) {
 	v, err := rcon.Do("GET", key)
 	if err != nil {
 		if err != rds.ErrNil {
-			log.Println("GET : " + err.Error())
+			logger.Error("GET", "err", err)
 		}
 		return
 	}
@@ -96,34 +118,59 @@ func getUsingChan(rcon rds.Conn, ch chan<- interface{}, key string) {
 
 // DEL 2 side
 func (h *RedisHandler) Del(key string) (int, error) {
-	err := h.Sema.Acquire()
+	err := h.acquire()
 	if err != nil {
 		return 0, err
 	}
 	defer h.Sema.Release()
-	log.Println("DEL", key)
+	logger.Command("DEL", key)
+	defer track("DEL", key, time.Now())
+	sp := startSpan("DEL", key)
+	defer sp.End()
+	dkey := rule.DestKey(key)
+	pol := rule.For(key)
+	if !pol.Migrate {
+		return rds.Int(originDo(sp, "DEL", key))
+	}
+	p := phase.Current()
+	if p == phase.DestOnly {
+		return rds.Int(destDo(sp, "DEL", dkey))
+	}
+
+	if err := checkWrite("DEL", p); err != nil {
+		return 0, err
+	}
 
-	origConn := connection.RedisPoolConnection.Origin.Get()
-	destConn := connection.RedisPoolConnection.Destination.Get()
+	unlock := lockWrite(key)
+	defer unlock()
 
-	chOrig := make(chan interface{})
-	chDest := make(chan interface{})
+	srcConn, srcKey, dstConn, dstKey := route(sp, p, key, dkey)
+	defer srcConn.Close()
+	defer dstConn.Close()
 
-	go delUsingChan(origConn, chOrig, key)
-	go delUsingChan(destConn, chDest, key)
+	chSrc := make(chan interface{})
+	chDst := make(chan interface{})
+
+	go delUsingChan(srcConn, chSrc, srcKey)
+	go delUsingChan(dstConn, chDst, dstKey)
 
 	// wait completion.
-	valOrig := <-chOrig
-	valDest := <-chDest
+	valSrc := <-chSrc
+	valDst := <-chDst
+	journalDown(p, audit.ActionDelete, key, "DEL", srcKey, dstKey)
 
 	// default exist value
-	valExist := valDest
+	valExist := valDst
 
-	if valDest == nil {
-		if valOrig == nil {
+	if valDst == nil {
+		if valSrc == nil {
 			return 0, errors.New("DEL : keys not found") //both nil, key not found
 		}
-		valExist = valOrig // set exist value
+		valExist = valSrc // set exist value
+	}
+
+	if p.OriginPrimary() {
+		valExist = valSrc // origin is authoritative
 	}
 
 	//check first if it is not internal error
@@ -142,7 +189,7 @@ func delUsingChan(rcon rds.Conn, ch chan<- interface{}, key string) {
 	v, err := rcon.Do("DEL", key)
 	if err != nil {
 		if err != rds.ErrNil {
-			log.Println("DEL : " + err.Error())
+			logger.Error("DEL", "err", err)
 		}
 		return
 	}
@@ -152,37 +199,63 @@ func delUsingChan(rcon rds.Conn, ch chan<- interface{}, key string) {
 
 // SET
 func (h *RedisHandler) Set(key string, value []byte) ([]byte, error) {
-	err := h.Sema.Acquire()
+	err := h.acquire()
 	if err != nil {
 		return nil, err
 	}
 	defer h.Sema.Release()
-	log.Println("SET", key, string(value))
+	logger.Command("SET", key, "value", logger.Value(value))
+	defer track("SET", key, time.Now())
+	sp := startSpan("SET", key)
+	defer sp.End()
+	dkey := rule.DestKey(key)
+	pol := rule.For(key)
+	if !pol.Migrate {
+		v, err := rds.String(originDo(sp, "SET", key, value))
+		return []byte(v), err
+	}
+	p := phase.Current()
+	if p == phase.DestOnly {
+		v, err := rds.String(destDo(sp, "SET", dkey, value))
+		return []byte(v), err
+	}
+
+	if err := checkWrite("SET", p); err != nil {
+		return nil, err
+	}
+
+	unlock := lockWrite(key)
+	defer unlock()
+
+	if p.OriginPrimary() {
+		v, err := rds.String(originDo(sp, "SET", key, value))
+		if err != nil {
+			return nil, errors.New("SET : err when set : " + err.Error())
+		}
+		err = mirror(sp, p, "SET", key, value)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(v), nil
+	}
 
-	origConn := connection.RedisPoolConnection.Origin.Get()
-	destConn := connection.RedisPoolConnection.Destination.Get()
+	//source is only written in background through its pipeline
+	srcKey := sideKey(p, true, key, dkey)
+	dstConn, dstKey := sideConn(sp, p, false, key, dkey)
+	defer dstConn.Close()
 
-	v, err := destConn.Do("SET", key, value)
+	v, err := dstConn.Do("SET", dstKey, value)
 	if err != nil {
 		return nil, errors.New("SET : err when set : " + err.Error())
 	}
 
-	if config.Cfg.General.Duplicate {
-		go func(skey string, svalue []byte) {
-			v, err = origConn.Do("SET", skey, svalue)
-			if err != nil {
-				log.Println("SET : err when set duplicate: " + err.Error())
-			}
-			return
-		}(key, value)
+	if pol.Duplicate {
+		sourceWrite(p, audit.ActionDuplicate, "string", key, len(value), "SET", srcKey, value)
 	}
-	//could ignore all in origin because set on dest already success
-	//del old key in origin
-	if !config.Cfg.General.Duplicate {
-		go func(skey string) {
-			origConn.Do("DEL", skey)
-			return
-		}(key)
+	//could ignore all in source because set on target already success
+	//del old key in source
+	if !pol.Duplicate {
+		sourceWrite(p, audit.ActionDelete, "string", key, 0, "DEL", srcKey)
 	}
 
 	strv, ok := v.(string)
@@ -194,42 +267,56 @@ func (h *RedisHandler) Set(key string, value []byte) ([]byte, error) {
 
 // HEXISTS 2 side
 func (h *RedisHandler) Hexists(key, field string) (int, error) {
-	err := h.Sema.Acquire()
+	err := h.acquire()
 	if err != nil {
 		return 0, err
 	}
 	defer h.Sema.Release()
-	log.Println("HEXISTS", key, field)
+	logger.Command("HEXISTS", key, "field", field)
+	defer track("HEXISTS", key, time.Now())
+	sp := startSpan("HEXISTS", key)
+	defer sp.End()
+	dkey := rule.DestKey(key)
+	pol := rule.For(key)
+	if !pol.Migrate {
+		return rds.Int(originDo(sp, "HEXISTS", key, field))
+	}
+	p := phase.Current()
+	if p == phase.DestOnly {
+		return rds.Int(destDo(sp, "HEXISTS", dkey, field))
+	}
 
-	origConn := connection.RedisPoolConnection.Origin.Get()
-	destConn := connection.RedisPoolConnection.Destination.Get()
+	srcConn, srcKey, dstConn, dstKey := route(sp, p, key, dkey)
+	defer srcConn.Close()
+	defer dstConn.Close()
 
-	chOrig := make(chan interface{})
-	chDest := make(chan interface{})
+	chSrc := make(chan interface{})
+	chDst := make(chan interface{})
 
-	go hexistsUsingChan(origConn, chOrig, key, field)
-	go hexistsUsingChan(destConn, chDest, key, field)
+	go hexistsUsingChan(srcConn, chSrc, srcKey, field)
+	go hexistsUsingChan(dstConn, chDst, dstKey, field)
 
 	// wait completion
-	valOrig := <-chOrig
-	valDest := <-chDest
+	valSrc := <-chSrc
+	valDst := <-chDst
 
 	// default exist value
-	valExist := valDest
+	valExist := valDst
+	fromSrc := false
 
-	if valDest == nil || valDest.(int64) == 0 {
-		if valOrig != nil && valOrig.(int64) == 1 {
-			//if this hash is in origin move it to destination
-			go func(skey string) {
-				err := moveHash(skey)
-				if err != nil {
-					log.Println(err)
-				}
-				return
-			}(key)
+	if valDst == nil || valDst.(int64) == 0 {
+		if valSrc != nil && valSrc.(int64) == 1 {
+			//if this hash is in source move it to target
+			submitMove(sp, "hash", key)
 		}
-		valExist = valOrig // set exist value
+		valExist, fromSrc = valSrc, true // set exist value
+	}
+
+	if p.OriginPrimary() && healthy("origin") {
+		valExist, fromSrc = valSrc, true // origin is authoritative
 	}
+	metrics.Hits.Inc(sideName(p, fromSrc))
+	sp.SetAttr("redisgrator.side", sideName(p, fromSrc))
 
 	//check first is it really not error from destination
 	int64v, ok := valExist.(int64)
@@ -247,7 +334,7 @@ func hexistsUsingChan(rcon rds.Conn, ch chan<- interface{}, key, field string) {
 	v, err := rcon.Do("HEXISTS", key, field)
 	if err != nil {
 		if err != rds.ErrNil {
-			log.Println("HEXISTS : " + err.Error())
+			logger.Error("HEXISTS", "err", err)
 		}
 		return
 	}
@@ -257,45 +344,67 @@ func hexistsUsingChan(rcon rds.Conn, ch chan<- interface{}, key, field string) {
 
 // HGET 2 side
 func (h *RedisHandler) Hget(key string, value []byte) ([]byte, error) {
-	err := h.Sema.Acquire()
+	err := h.acquire()
 	if err != nil {
 		return nil, err
 	}
 	defer h.Sema.Release()
-	log.Println("HGET", key, string(value))
+	logger.Command("HGET", key, "field", string(value))
+	defer track("HGET", key, time.Now())
+	sp := startSpan("HGET", key)
+	defer sp.End()
+	dkey := rule.DestKey(key)
+	pol := rule.For(key)
+	if !pol.Migrate {
+		v, err := rds.Bytes(originDo(sp, "HGET", key, value))
+		if err == rds.ErrNil {
+			return nil, nil
+		}
+		return v, err
+	}
+	p := phase.Current()
+	if p == phase.DestOnly {
+		v, err := rds.Bytes(destDo(sp, "HGET", dkey, value))
+		if err == rds.ErrNil {
+			return nil, nil
+		}
+		return v, err
+	}
 
-	origConn := connection.RedisPoolConnection.Origin.Get()
-	destConn := connection.RedisPoolConnection.Destination.Get()
+	srcConn, srcKey, dstConn, dstKey := route(sp, p, key, dkey)
+	defer srcConn.Close()
+	defer dstConn.Close()
 
-	chOrig := make(chan interface{})
-	chDest := make(chan interface{})
+	chSrc := make(chan interface{})
+	chDst := make(chan interface{})
 
-	go hgetUsingChan(origConn, chOrig, key, value)
-	go hgetUsingChan(destConn, chDest, key, value)
+	go hgetUsingChan(srcConn, chSrc, srcKey, value)
+	go hgetUsingChan(dstConn, chDst, dstKey, value)
 
 	// wait completion.
-	valOrig := <-chOrig
-	valDest := <-chDest
+	valSrc := <-chSrc
+	valDst := <-chDst
 
 	// default exist value
-	valExist := valDest
-
-	if valDest == nil {
-		if valOrig != nil {
-			if config.Cfg.General.SetToDestWhenGet {
-				go func(skey string) {
-					//if this hash is in origin move it to destination
-					err := moveHash(skey)
-					if err != nil {
-						log.Println(err)
-					}
-					return
-				}(key)
+	valExist := valDst
+	fromSrc := false
+
+	if valDst == nil {
+		if valSrc != nil {
+			if pol.SetToDestWhenGet {
+				submitMove(sp, "hash", key)
 			}
 		}
-		valExist = valOrig // set exist value
+		valExist, fromSrc = valSrc, true // set exist value
 	}
 
+	if p.OriginPrimary() && healthy("origin") {
+		valExist, fromSrc = valSrc, true // origin is authoritative
+		shadowRead("HGET", key, valSrc, valDst)
+	}
+	metrics.Hits.Inc(sideName(p, fromSrc))
+	sp.SetAttr("redisgrator.side", sideName(p, fromSrc))
+
 	bytv, ok := valExist.([]byte)
 	strv := string(bytv)
 	if ok == false {
@@ -311,7 +420,7 @@ func hgetUsingChan(rcon rds.Conn, ch chan<- interface{}, key string, value []byt
 	v, err := rcon.Do("HGET", key, value)
 	if err != nil {
 		if err != rds.ErrNil {
-			log.Println("HGET : " + err.Error())
+			logger.Error("HGET", "err", err)
 		}
 		return
 	}
@@ -321,49 +430,66 @@ func hgetUsingChan(rcon rds.Conn, ch chan<- interface{}, key string, value []byt
 
 // HGETALL 2 side
 func (h *RedisHandler) Hgetall(key string) ([]interface{}, error) {
-	err := h.Sema.Acquire()
+	err := h.acquire()
 	if err != nil {
 		return nil, err
 	}
 	defer h.Sema.Release()
-	log.Println("HGETALL", key)
+	logger.Command("HGETALL", key)
+	defer track("HGETALL", key, time.Now())
+	sp := startSpan("HGETALL", key)
+	defer sp.End()
+	dkey := rule.DestKey(key)
+	pol := rule.For(key)
+	if !pol.Migrate {
+		return rds.Values(originDo(sp, "HGETALL", key))
+	}
+	p := phase.Current()
+	if p == phase.DestOnly {
+		return rds.Values(destDo(sp, "HGETALL", dkey))
+	}
 
-	origConn := connection.RedisPoolConnection.Origin.Get()
-	destConn := connection.RedisPoolConnection.Destination.Get()
+	srcConn, srcKey, dstConn, dstKey := route(sp, p, key, dkey)
+	defer srcConn.Close()
+	defer dstConn.Close()
 
-	chOrig := make(chan interface{})
-	chDest := make(chan interface{})
+	chSrc := make(chan interface{})
+	chDst := make(chan interface{})
 
-	go hgetallUsingChan(origConn, chOrig, key)
-	go hgetallUsingChan(destConn, chDest, key)
+	go hgetallUsingChan(srcConn, chSrc, srcKey)
+	go hgetallUsingChan(dstConn, chDst, dstKey)
 
 	// wait completion.
-	valOrig := <-chOrig
-	valDest := <-chDest
+	valSrc := <-chSrc
+	valDst := <-chDst
 
 	// default exist value
-	valExist := valDest
+	valExist := valDst
+	fromSrc := false
 	var empty []interface{}
 
-	valDestArr, ok := valDest.([]interface{})
-	if valDest == nil || !ok || len(valDestArr) == 0 {
-		valOrigArr, ok := valOrig.([]interface{})
-		if valOrig == nil || !ok || len(valOrigArr) == 0 {
+	valDstArr, ok := valDst.([]interface{})
+	if valDst == nil || !ok || len(valDstArr) == 0 {
+		valSrcArr, ok := valSrc.([]interface{})
+		if valSrc == nil || !ok || len(valSrcArr) == 0 {
 			return empty, errors.New("HGETALL : keys not found")
 		} else {
-			if config.Cfg.General.SetToDestWhenGet {
-				go func(skey string) {
-					//if this hash is in origin move it to destination
-					err := moveHash(skey)
-					if err != nil {
-						log.Println(err)
-					}
-					return
-				}(key)
+			if pol.SetToDestWhenGet {
+				submitMove(sp, "hash", key)
 			}
 		}
-		valExist = valOrig // set exist value
+		valExist, fromSrc = valSrc, true // set exist value
+	}
+
+	if chunkInProgress(key) {
+		valExist = mergeReply("hash", valSrc, valDst) // big key half moved
+	}
+	if p.OriginPrimary() && healthy("origin") {
+		valExist, fromSrc = valSrc, true // origin is authoritative
+		shadowRead("HGETALL", key, valSrc, valDst)
 	}
+	metrics.Hits.Inc(sideName(p, fromSrc))
+	sp.SetAttr("redisgrator.side", sideName(p, fromSrc))
 
 	result, ok := valExist.([]interface{})
 	if ok == false {
@@ -379,7 +505,7 @@ func hgetallUsingChan(rcon rds.Conn, ch chan<- interface{}, key string) {
 	v, err := rcon.Do("HGETALL", key)
 	if err != nil {
 		if err != rds.ErrNil {
-			log.Println("HGETALL : " + err.Error())
+			logger.Error("HGETALL", "err", err)
 		}
 		return
 	}
@@ -389,40 +515,70 @@ func hgetallUsingChan(rcon rds.Conn, ch chan<- interface{}, key string) {
 
 // HSET
 func (h *RedisHandler) Hset(key, field string, value []byte) (int, error) {
-	err := h.Sema.Acquire()
+	err := h.acquire()
 	if err != nil {
 		return 0, err
 	}
 	defer h.Sema.Release()
-	log.Println("HSET", key, field, string(value))
+	logger.Command("HSET", key, "field", field, "value", logger.Value(value))
+	defer track("HSET", key, time.Now())
+	sp := startSpan("HSET", key)
+	defer sp.End()
+	dkey := rule.DestKey(key)
+	pol := rule.For(key)
+	if !pol.Migrate {
+		return rds.Int(originDo(sp, "HSET", key, field, value))
+	}
+	p := phase.Current()
+	if p == phase.DestOnly {
+		return rds.Int(destDo(sp, "HSET", dkey, field, value))
+	}
+
+	if err := checkWrite("HSET", p); err != nil {
+		return 0, err
+	}
 
-	origConn := connection.RedisPoolConnection.Origin.Get()
-	destConn := connection.RedisPoolConnection.Destination.Get()
-	v, err := origConn.Do("EXISTS", key)
+	unlock := lockWrite(key)
+	defer unlock()
+
+	if p.OriginPrimary() {
+		v, err := rds.Int(originDo(sp, "HSET", key, field, value))
+		if err != nil {
+			return 0, errors.New("HSET : err when set : " + err.Error())
+		}
+		err = mirror(sp, p, "HSET", key, []byte(field), value)
+		if err != nil {
+			return 0, err
+		}
+		return v, nil
+	}
+
+	srcConn, srcKey := sideConn(sp, p, true, key, dkey)
+	dstKey := sideKey(p, false, key, dkey)
+	var v interface{} = int64(0)
+	if !sourceDown(p, key, "HSET", dstKey) {
+		v, err = srcConn.Do("EXISTS", srcKey)
+	}
+	//move take its own connection, give it back first so a full pool can not deadlock
+	srcConn.Close()
 	if err != nil {
-		return 0, errors.New("HSET : err when check exist in origin : " + err.Error())
+		return 0, errors.New("HSET : err when check exist in source : " + err.Error())
 	}
 	if v.(int64) == 1 {
-		//if hash exists move all hash first to destination
-		err := moveHash(key)
+		//if hash exists move all hash first to target
+		err := moveHash(sp, key)
 		if err != nil {
 			return 0, err
 		}
 	}
 
-	v, err = destConn.Do("HSET", key, field, value)
+	v, err = targetDo(sp, p, "HSET", dstKey, field, value)
 	if err != nil {
 		return 0, errors.New("HSET : err when set : " + err.Error())
 	}
 
-	if config.Cfg.General.Duplicate {
-		go func(skey, sfield string, svalue []byte) {
-			v, err = origConn.Do("HSET", skey, sfield, svalue)
-			if err != nil {
-				log.Println("HSET : err when set : " + err.Error())
-			}
-			return
-		}(key, field, value)
+	if pol.Duplicate {
+		sourceWrite(p, audit.ActionDuplicate, "hash", key, len(value), "HSET", srcKey, field, value)
 	}
 
 	int64v, ok := v.(int64)
@@ -435,46 +591,61 @@ func (h *RedisHandler) Hset(key, field string, value []byte) (int, error) {
 
 // SISMEMBER 2 side
 func (h *RedisHandler) Sismember(set, field string) (int, error) {
-	err := h.Sema.Acquire()
+	err := h.acquire()
 	if err != nil {
 		return 0, err
 	}
 	defer h.Sema.Release()
 
-	log.Println("SISMEMBER", set, field)
+	logger.Command("SISMEMBER", set, "member", logger.Value([]byte(field)))
+	defer track("SISMEMBER", set, time.Now())
+	sp := startSpan("SISMEMBER", set)
+	defer sp.End()
+	dset := rule.DestKey(set)
+	pol := rule.For(set)
+	if !pol.Migrate {
+		return rds.Int(originDo(sp, "SISMEMBER", set, field))
+	}
+	p := phase.Current()
+	if p == phase.DestOnly {
+		return rds.Int(destDo(sp, "SISMEMBER", dset, field))
+	}
 
-	origConn := connection.RedisPoolConnection.Origin.Get()
-	destConn := connection.RedisPoolConnection.Destination.Get()
+	srcConn, srcKey, dstConn, dstKey := route(sp, p, set, dset)
+	defer srcConn.Close()
+	defer dstConn.Close()
 
-	chOrig := make(chan interface{})
-	chDest := make(chan interface{})
+	chSrc := make(chan interface{})
+	chDst := make(chan interface{})
 
-	go sismemberUsingChan(origConn, chOrig, set, field)
-	go sismemberUsingChan(destConn, chDest, set, field)
+	go sismemberUsingChan(srcConn, chSrc, srcKey, field)
+	go sismemberUsingChan(dstConn, chDst, dstKey, field)
 
 	// wait completion.
-	valOrig := <-chOrig
-	valDest := <-chDest
+	valSrc := <-chSrc
+	valDst := <-chDst
 
 	// default exist value
-	valExist := valDest
+	valExist := valDst
+	fromSrc := false
 
-	if valDest == nil || valDest.(int64) == 0 {
-		if valOrig == nil || valOrig.(int64) == 0 {
+	if valDst == nil || valDst.(int64) == 0 {
+		if valSrc == nil || valSrc.(int64) == 0 {
 			return 0, nil // both nil, key not found
 		} else {
 			//move all set
-			go func(sset string) {
-				err := moveSet(sset)
-				if err != nil {
-					log.Println(err)
-				}
-				return
-			}(set)
+			submitMove(sp, "set", set)
 		}
-		valExist = valOrig
+		valExist, fromSrc = valSrc, true
 	}
 
+	if p.OriginPrimary() && healthy("origin") {
+		valExist, fromSrc = valSrc, true // origin is authoritative
+		shadowRead("SISMEMBER", set, valSrc, valDst)
+	}
+	metrics.Hits.Inc(sideName(p, fromSrc))
+	sp.SetAttr("redisgrator.side", sideName(p, fromSrc))
+
 	int64v, ok := valExist.(int64)
 	intv := int(int64v)
 	if ok == false {
@@ -490,7 +661,7 @@ func sismemberUsingChan(rcon rds.Conn, ch chan<- interface{}, set, field string)
 	v, err := rcon.Do("SISMEMBER", set, field)
 	if err != nil {
 		if err != rds.ErrNil {
-			log.Println("SISMEMBER : " + err.Error())
+			logger.Error("SISMEMBER", "err", err)
 		}
 		return
 	}
@@ -500,47 +671,65 @@ func sismemberUsingChan(rcon rds.Conn, ch chan<- interface{}, set, field string)
 
 // SMEMBERS 2 side
 func (h *RedisHandler) Smembers(set string) ([]interface{}, error) {
-	err := h.Sema.Acquire()
+	err := h.acquire()
 	if err != nil {
 		return nil, err
 	}
 	defer h.Sema.Release()
-	log.Println("SMEMBERS", set)
+	logger.Command("SMEMBERS", set)
+	defer track("SMEMBERS", set, time.Now())
+	sp := startSpan("SMEMBERS", set)
+	defer sp.End()
+	dset := rule.DestKey(set)
+	pol := rule.For(set)
+	if !pol.Migrate {
+		return rds.Values(originDo(sp, "SMEMBERS", set))
+	}
+	p := phase.Current()
+	if p == phase.DestOnly {
+		return rds.Values(destDo(sp, "SMEMBERS", dset))
+	}
 
-	origConn := connection.RedisPoolConnection.Origin.Get()
-	destConn := connection.RedisPoolConnection.Destination.Get()
+	srcConn, srcKey, dstConn, dstKey := route(sp, p, set, dset)
+	defer srcConn.Close()
+	defer dstConn.Close()
 
-	chOrig := make(chan interface{})
-	chDest := make(chan interface{})
+	chSrc := make(chan interface{})
+	chDst := make(chan interface{})
 
-	go smemberUsingChan(origConn, chOrig, set)
-	go smemberUsingChan(destConn, chDest, set)
+	go smemberUsingChan(srcConn, chSrc, srcKey)
+	go smemberUsingChan(dstConn, chDst, dstKey)
 
 	// wait completion.
-	valOrig := <-chOrig
-	valDest := <-chDest
+	valSrc := <-chSrc
+	valDst := <-chDst
 
 	// default exist value
-	valExist := valDest
+	valExist := valDst
+	fromSrc := false
 	var empty []interface{}
 
-	valDestArr, ok := valDest.([]interface{})
-	if valDest == nil || !ok || len(valDestArr) == 0 {
-		valOrigArr, ok := valOrig.([]interface{})
-		if valOrig == nil || !ok || len(valOrigArr) == 0 {
+	valDstArr, ok := valDst.([]interface{})
+	if valDst == nil || !ok || len(valDstArr) == 0 {
+		valSrcArr, ok := valSrc.([]interface{})
+		if valSrc == nil || !ok || len(valSrcArr) == 0 {
 			return empty, errors.New("SMEMBERS : keys not found") // both nil, key not found
 		} else {
 			//move all set
-			go func(sset string) {
-				err := moveSet(sset)
-				if err != nil {
-					log.Println(err)
-				}
-				return
-			}(set)
+			submitMove(sp, "set", set)
 		}
-		valExist = valOrig // set exist value
+		valExist, fromSrc = valSrc, true // set exist value
+	}
+
+	if chunkInProgress(set) {
+		valExist = mergeReply("set", valSrc, valDst) // big key half moved
+	}
+	if p.OriginPrimary() && healthy("origin") {
+		valExist, fromSrc = valSrc, true // origin is authoritative
+		shadowRead("SMEMBERS", set, valSrc, valDst)
 	}
+	metrics.Hits.Inc(sideName(p, fromSrc))
+	sp.SetAttr("redisgrator.side", sideName(p, fromSrc))
 
 	result, ok := valExist.([]interface{})
 	if ok == false {
@@ -556,7 +745,7 @@ func smemberUsingChan(rcon rds.Conn, ch chan<- interface{}, set string) {
 	v, err := rcon.Do("SMEMBERS", set)
 	if err != nil {
 		if err != rds.ErrNil {
-			log.Println("SMEMBERS : " + err.Error())
+			logger.Error("SMEMBERS", "err", err)
 		}
 		return
 	}
@@ -566,40 +755,70 @@ func smemberUsingChan(rcon rds.Conn, ch chan<- interface{}, set string) {
 
 // SADD
 func (h *RedisHandler) Sadd(set string, val []byte) (int, error) {
-	err := h.Sema.Acquire()
+	err := h.acquire()
 	if err != nil {
 		return 0, err
 	}
 	defer h.Sema.Release()
-	log.Println("SADD", set, string(val))
+	logger.Command("SADD", set, "member", logger.Value(val))
+	defer track("SADD", set, time.Now())
+	sp := startSpan("SADD", set)
+	defer sp.End()
+	dset := rule.DestKey(set)
+	pol := rule.For(set)
+	if !pol.Migrate {
+		return rds.Int(originDo(sp, "SADD", set, val))
+	}
+	p := phase.Current()
+	if p == phase.DestOnly {
+		return rds.Int(destDo(sp, "SADD", dset, val))
+	}
 
-	origConn := connection.RedisPoolConnection.Origin.Get()
-	destConn := connection.RedisPoolConnection.Destination.Get()
+	if err := checkWrite("SADD", p); err != nil {
+		return 0, err
+	}
 
-	v, err := origConn.Do("EXISTS", set)
+	unlock := lockWrite(set)
+	defer unlock()
+
+	if p.OriginPrimary() {
+		v, err := rds.Int(originDo(sp, "SADD", set, val))
+		if err != nil {
+			return 0, errors.New("SADD : err when set : " + err.Error())
+		}
+		err = mirror(sp, p, "SADD", set, val)
+		if err != nil {
+			return 0, err
+		}
+		return v, nil
+	}
+
+	srcConn, srcKey := sideConn(sp, p, true, set, dset)
+	dstKey := sideKey(p, false, set, dset)
+
+	var v interface{} = int64(0)
+	if !sourceDown(p, set, "SADD", dstKey) {
+		v, err = srcConn.Do("EXISTS", srcKey)
+	}
+	//move take its own connection, give it back first so a full pool can not deadlock
+	srcConn.Close()
 	if err != nil {
-		return 0, errors.New("SADD : err when check exist in origin : " + err.Error())
+		return 0, errors.New("SADD : err when check exist in source : " + err.Error())
 	}
 	if v.(int64) == 1 {
-		//if set exists move all set first to destination
-		err := moveSet(set)
+		//if set exists move all set first to target
+		err := moveSet(sp, set)
 		if err != nil {
 			return 0, err
 		}
 	}
 
-	v, err = destConn.Do("SADD", set, val)
+	v, err = targetDo(sp, p, "SADD", dstKey, val)
 	if err != nil {
-		return 0, errors.New("SADD : err when check exist in origin : " + err.Error())
+		return 0, errors.New("SADD : err when check exist in source : " + err.Error())
 	}
-	if config.Cfg.General.Duplicate {
-		go func(sset string, sval []byte) {
-			v, err = origConn.Do("SADD", sset, sval)
-			if err != nil {
-				log.Println("SADD : err when check exist in origin : " + err.Error())
-			}
-			return
-		}(set, val)
+	if pol.Duplicate {
+		sourceWrite(p, audit.ActionDuplicate, "set", set, len(val), "SADD", srcKey, val)
 	}
 
 	int64v, ok := v.(int64)
@@ -612,29 +831,56 @@ func (h *RedisHandler) Sadd(set string, val []byte) (int, error) {
 
 // SREM
 func (h *RedisHandler) Srem(set string, val []byte) (int, error) {
-	err := h.Sema.Acquire()
+	err := h.acquire()
 	if err != nil {
 		return 0, err
 	}
 	defer h.Sema.Release()
-	log.Println("SREM", set, string(val))
+	logger.Command("SREM", set, "member", logger.Value(val))
+	defer track("SREM", set, time.Now())
+	sp := startSpan("SREM", set)
+	defer sp.End()
+	dset := rule.DestKey(set)
+	pol := rule.For(set)
+	if !pol.Migrate {
+		return rds.Int(originDo(sp, "SREM", set, val))
+	}
+	p := phase.Current()
+	if p == phase.DestOnly {
+		return rds.Int(destDo(sp, "SREM", dset, val))
+	}
+
+	if err := checkWrite("SREM", p); err != nil {
+		return 0, err
+	}
 
-	origConn := connection.RedisPoolConnection.Origin.Get()
-	destConn := connection.RedisPoolConnection.Destination.Get()
+	unlock := lockWrite(set)
+	defer unlock()
 
-	v, err := destConn.Do("SREM", set, val)
+	if p.OriginPrimary() {
+		v, err := rds.Int(originDo(sp, "SREM", set, val))
+		if err != nil {
+			return 0, errors.New("SREM : err when set : " + err.Error())
+		}
+		err = mirror(sp, p, "SREM", set, val)
+		if err != nil {
+			return 0, err
+		}
+		return v, nil
+	}
+
+	//source is only written in background through its pipeline
+	srcKey := sideKey(p, true, set, dset)
+	dstConn, dstKey := sideConn(sp, p, false, set, dset)
+	defer dstConn.Close()
+
+	v, err := dstConn.Do("SREM", dstKey, val)
 	if err != nil {
-		return 0, errors.New("SREM : err when check exist in origin : " + err.Error())
+		return 0, errors.New("SREM : err when check exist in source : " + err.Error())
 	}
 
-	if config.Cfg.General.Duplicate {
-		go func(sset string, sval []byte) {
-			v, err = origConn.Do("SREM", sset, sval)
-			if err != nil {
-				log.Println("SREM : err when check exist in origin : " + err.Error())
-			}
-			return
-		}(set, val)
+	if pol.Duplicate {
+		sourceWrite(p, audit.ActionDuplicate, "set", set, len(val), "SREM", srcKey, val)
 	}
 	int64v, ok := v.(int64)
 	intv := int(int64v)
@@ -646,36 +892,63 @@ func (h *RedisHandler) Srem(set string, val []byte) (int, error) {
 
 // SETEX
 func (h *RedisHandler) Setex(key string, value int, val string) ([]byte, error) {
-	err := h.Sema.Acquire()
+	err := h.acquire()
 	if err != nil {
 		return nil, err
 	}
 	defer h.Sema.Release()
-	log.Println("SETEX", key, value, val)
+	logger.Command("SETEX", key, "ttl", value, "value", logger.Value([]byte(val)))
+	defer track("SETEX", key, time.Now())
+	sp := startSpan("SETEX", key)
+	defer sp.End()
+	dkey := rule.DestKey(key)
+	pol := rule.For(key)
+	if !pol.Migrate {
+		v, err := rds.String(originDo(sp, "SETEX", key, value, val))
+		return []byte(v), err
+	}
+	p := phase.Current()
+	if p == phase.DestOnly {
+		v, err := rds.String(destDo(sp, "SETEX", dkey, value, val))
+		return []byte(v), err
+	}
 
-	origConn := connection.RedisPoolConnection.Origin.Get()
-	destConn := connection.RedisPoolConnection.Destination.Get()
+	if err := checkWrite("SETEX", p); err != nil {
+		return nil, err
+	}
 
-	v, err := destConn.Do("SETEX", key, value, val)
+	unlock := lockWrite(key)
+	defer unlock()
+
+	if p.OriginPrimary() {
+		v, err := rds.String(originDo(sp, "SETEX", key, value, val))
+		if err != nil {
+			return nil, errors.New("SETEX : err when set : " + err.Error())
+		}
+		err = mirror(sp, p, "SETEX", key, []byte(strconv.Itoa(value)), []byte(val))
+		if err != nil {
+			return nil, err
+		}
+		return []byte(v), nil
+	}
+
+	//source is only written in background through its pipeline
+	srcKey := sideKey(p, true, key, dkey)
+	dstConn, dstKey := sideConn(sp, p, false, key, dkey)
+	defer dstConn.Close()
+
+	v, err := dstConn.Do("SETEX", dstKey, value, val)
 	if err != nil {
 		return nil, errors.New("SETEX : err when set : " + err.Error())
 	}
 
-	if config.Cfg.General.Duplicate {
-		go func(skey string, svalue int, sval string) {
-			v, err = origConn.Do("SETEX", skey, svalue, sval)
-			if err != nil {
-				log.Println("SETEX : err when set duplicate: " + err.Error())
-			}
-		}(key, value, val)
+	if pol.Duplicate {
+		sourceWrite(p, audit.ActionDuplicate, "string", key, len(val), "SETEX", srcKey, value, val)
 	}
-	//could ignore all in origin because set on dest already success
-	//del old key in origin
-	if !config.Cfg.General.Duplicate {
-		go func(skey string) {
-			origConn.Do("DEL", skey)
-			return
-		}(key)
+	//could ignore all in source because set on target already success
+	//del old key in source
+	if !pol.Duplicate {
+		sourceWrite(p, audit.ActionDelete, "string", key, 0, "DEL", srcKey)
 	}
 
 	strv, ok := v.(string)
@@ -687,34 +960,59 @@ func (h *RedisHandler) Setex(key string, value int, val string) ([]byte, error)
 
 // EXPIRE
 func (h *RedisHandler) Expire(key string, value int) (int, error) {
-	err := h.Sema.Acquire()
+	err := h.acquire()
 	if err != nil {
 		return 0, err
 	}
 	defer h.Sema.Release()
-	log.Println("EXPIRE", key, value)
+	logger.Command("EXPIRE", key, "ttl", value)
+	defer track("EXPIRE", key, time.Now())
+	sp := startSpan("EXPIRE", key)
+	defer sp.End()
+	dkey := rule.DestKey(key)
+	pol := rule.For(key)
+	if !pol.Migrate {
+		return rds.Int(originDo(sp, "EXPIRE", key, value))
+	}
+	p := phase.Current()
+	if p == phase.DestOnly {
+		return rds.Int(destDo(sp, "EXPIRE", dkey, value))
+	}
 
-	origConn := connection.RedisPoolConnection.Origin.Get()
-	destConn := connection.RedisPoolConnection.Destination.Get()
+	if err := checkWrite("EXPIRE", p); err != nil {
+		return 0, err
+	}
+
+	unlock := lockWrite(key)
+	defer unlock()
 
-	chOrig := make(chan interface{})
-	chDest := make(chan interface{})
+	srcConn, srcKey, dstConn, dstKey := route(sp, p, key, dkey)
+	defer srcConn.Close()
+	defer dstConn.Close()
 
-	go expireUsingChan(origConn, chOrig, key, value)
-	go expireUsingChan(destConn, chDest, key, value)
+	chSrc := make(chan interface{})
+	chDst := make(chan interface{})
+
+	go expireUsingChan(srcConn, chSrc, srcKey, value)
+	go expireUsingChan(dstConn, chDst, dstKey, value)
 
 	// wait completion.
-	valOrig := <-chOrig
-	valDest := <-chDest
+	valSrc := <-chSrc
+	valDst := <-chDst
+	journalDown(p, audit.ActionDuplicate, key, "EXPIRE", srcKey, dstKey, value)
 
 	// default exist value
-	valExist := valDest
+	valExist := valDst
 
-	if valDest == nil || valDest.(int64) == 0 {
-		if valOrig == nil {
+	if valDst == nil || valDst.(int64) == 0 {
+		if valSrc == nil {
 			return 0, errors.New("EXPIRE : keys not found") //both nil, key not found
 		}
-		valExist = valOrig
+		valExist = valSrc
+	}
+
+	if p.OriginPrimary() {
+		valExist = valSrc // origin is authoritative
 	}
 
 	int64v, ok := valExist.(int64)
@@ -732,7 +1030,7 @@ func expireUsingChan(rcon rds.Conn, ch chan<- interface{}, key string, value int
 	v, err := rcon.Do("EXPIRE", key, value)
 	if err != nil {
 		if err != rds.ErrNil {
-			log.Println("EXPIRE : " + err.Error())
+			logger.Error("EXPIRE", "err", err)
 		}
 		return
 	}
@@ -740,81 +1038,130 @@ func expireUsingChan(rcon rds.Conn, ch chan<- interface{}, key string, value int
 	return
 }
 
-// INFO
-func (h *RedisHandler) Info() ([]byte, error) {
-	return []byte(fmt.Sprintf(
-		`#Server
-		redisgrator 0.0.1
-		uptime_in_seconds: %d
-		#Stats
-		number_of_reads_per_second: %d
-		`, int(time.Since(h.Start).Seconds()), 0)), nil
+// run command only in origin, used for keys that are not migrated
+func originDo(sp *trace.Span, cmd string, args ...interface{}) (interface{}, error) {
+	conn := traced(connection.RedisPoolConnection.Origin.Get(), sp, "origin")
+	defer conn.Close()
+	return conn.Do(cmd, args...)
 }
 
-func moveHash(key string) error {
-	if config.Cfg.General.MoveHash {
-		origConn := connection.RedisPoolConnection.Origin.Get()
-		destConn := connection.RedisPoolConnection.Destination.Get()
+// run command only in destination
+func destDo(sp *trace.Span, cmd string, args ...interface{}) (interface{}, error) {
+	conn := traced(connection.RedisPoolConnection.Destination.Get(), sp, "destination")
+	defer conn.Close()
+	return conn.Do(cmd, args...)
+}
 
-		v, err := origConn.Do("HGETALL", key)
-		if err != nil {
-			return err
+// run command only on target side of p
+func targetDo(sp *trace.Span, p phase.Phase, cmd string, args ...interface{}) (interface{}, error) {
+	if p == phase.Rollback {
+		return originDo(sp, cmd, args...)
+	}
+	return destDo(sp, cmd, args...)
+}
+
+// apply write to destination while origin is primary,
+// dual write wait for the result while shadow queue it to background worker
+func mirror(sp *trace.Span, p phase.Phase, cmd, key string, args ...[]byte) error {
+	t := worker.Task{Kind: taskMirror, Key: key, Cmd: cmd, Args: args, Span: sp}
+	if p == phase.DualWrite {
+		iargs := make([]interface{}, len(args))
+		for i, a := range args {
+			iargs[i] = a
 		}
-		//check first is v really array of interface
-		arrval, ok := v.([]interface{})
-		if ok == true {
-			for i, val := range arrval {
-				valstr := string(val.([]byte))
-				if i%2 == 0 {
-					_, err := destConn.Do("HSET", key, valstr, arrval[i+1].([]byte))
-					if err != nil {
-						return errors.New("err when set on hexist : " + err.Error())
-					}
-					log.Println("INSIDE MOVEHASH HSET", key, valstr, string(arrval[i+1].([]byte)))
-				}
-			}
-			if !config.Cfg.General.Duplicate {
-				_, err = origConn.Do("DEL", key)
-				if err != nil {
-					return errors.New("err when del on hexist : " + err.Error())
-				}
-				log.Println("INSIDE MOVEHASH DEL", key)
-			}
+		err := mirrorWrite(sp, cmd, key, iargs...)
+		if err == connection.ErrUnavailable && queueDegraded() {
+			//destination is down, write is replayed from journal once it is back
+			journalTask(t, err)
+			return nil
 		}
+		if err != nil {
+			return errors.New(cmd + " : err when dual write : " + err.Error())
+		}
+		return nil
 	}
+	submit(t)
 	return nil
 }
 
-func moveSet(set string) error {
-	if config.Cfg.General.MoveSet {
-		origConn := connection.RedisPoolConnection.Origin.Get()
-		destConn := connection.RedisPoolConnection.Destination.Get()
-
-		v, err := origConn.Do("SMEMBERS", set)
+// write to destination, hash and set missing there is moved whole before single field or member is written
+func mirrorWrite(sp *trace.Span, cmd, key string, args ...interface{}) error {
+	dkey := rule.DestKey(key)
+	var move func(*trace.Span, string) error
+	switch cmd {
+	case "HSET":
+		move = moveHash
+	case "SADD":
+		move = moveSet
+	}
+	if move != nil {
+		n, err := rds.Int(destDo(sp, "EXISTS", dkey))
+		if err == nil && n == 0 {
+			err = move(sp, key)
+		}
 		if err != nil {
 			return err
 		}
-		//check first is v really array of interface
-		arrval, ok := v.([]interface{})
-		if ok == true {
-			for _, val := range arrval {
-				valstr := string(val.([]byte))
-				//add all members of set to destination
-				_, err := destConn.Do("SADD", set, valstr)
-				if err != nil {
-					return errors.New("err when set on hexist : keys exist as different type : " + err.Error())
-				}
-				log.Println("INSIDE MOVESET SADD", set, valstr)
-			}
-			if !config.Cfg.General.Duplicate {
-				//delete from origin
-				_, err = origConn.Do("DEL", set)
-				if err != nil {
-					return errors.New("err when del on hexist : " + err.Error())
-				}
-				log.Println("INSIDE MOVESET DEL", set)
-			}
-		}
 	}
-	return nil
+	_, err := destDo(sp, cmd, append([]interface{}{dkey}, args...)...)
+	return err
+}
+
+// write to source side in background, queued to the worker of key so it keep key order
+// and follow the overflow policy of other background write
+func sourceWrite(p phase.Phase, action, typ, key string, size int, cmd string, args ...interface{}) {
+	submit(worker.Task{Kind: taskSource, Key: key, Cmd: cmd, Args: bytesArgs(args),
+		Side: sideName(p, true), Action: action, Type: typ, Size: size})
+}
+
+// side keys are moved from (source) and moved to (target) with key name on each side,
+// rollback reverse the direction so keys flow from destination back to origin
+func route(sp *trace.Span, p phase.Phase, key, dkey string) (srcConn rds.Conn, srcKey string, dstConn rds.Conn, dstKey string) {
+	srcConn, srcKey = sideConn(sp, p, true, key, dkey)
+	dstConn, dstKey = sideConn(sp, p, false, key, dkey)
+	return srcConn, srcKey, dstConn, dstKey
+}
+
+// connection to source or target side of p only, with key name on that side,
+// use it instead of route when the other side is not needed
+func sideConn(sp *trace.Span, p phase.Phase, fromSrc bool, key, dkey string) (rds.Conn, string) {
+	if sideName(p, fromSrc) == "destination" {
+		return traced(connection.RedisPoolConnection.Destination.Get(), sp, "destination"), dkey
+	}
+	return traced(connection.RedisPoolConnection.Origin.Get(), sp, "origin"), key
+}
+
+// key name on source or target side of p
+func sideKey(p phase.Phase, fromSrc bool, key, dkey string) string {
+	if sideName(p, fromSrc) == "destination" {
+		return dkey
+	}
+	return key
+}
+
+// acquire semaphore ticket, recording wait time and timeout
+func (h *RedisHandler) acquire() error {
+	start := time.Now()
+	err := h.Sema.Acquire()
+	metrics.SemaWait.Observe(time.Since(start).Seconds())
+	if err != nil {
+		metrics.SemaTimeouts.Inc()
+	}
+	return err
+}
+
+// count command and record its latency, meant to be deferred
+func track(cmd, key string, start time.Time) {
+	d := time.Since(start)
+	metrics.Commands.Inc(cmd)
+	metrics.CommandLatency.Observe(d.Seconds(), cmd)
+	slowlog.Record(cmd, logger.Key(key), d)
+}
+
+// name of upstream side that answered the read
+func sideName(p phase.Phase, fromSrc bool) string {
+	if fromSrc == (p == phase.Rollback) {
+		return "destination"
+	}
+	return "origin"
 }
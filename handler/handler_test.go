@@ -0,0 +1,146 @@
+package handler
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/eapache/go-resiliency/semaphore"
+	"github.com/tokopedia/redisgrator/config"
+	"github.com/tokopedia/redisgrator/connection"
+	"github.com/tokopedia/redisgrator/journal"
+	"github.com/tokopedia/redisgrator/phase"
+	"github.com/tokopedia/redisgrator/redistest"
+)
+
+// pool dialing in memory redis, dial fail while down is set
+func fakePool(side string, r *redistest.Redis, down *bool) *connection.Pool {
+	p := connection.NewPool(side, "", connection.Options{MaxIdle: 10,
+		BreakerErrors: 1, BreakerSuccesses: 1, BreakerTimeout: time.Hour})
+	p.Pool.Dial = redistest.Dial(r, down)
+	return p
+}
+
+type fixture struct {
+	h          *RedisHandler
+	orig, dest *redistest.Redis
+	pools      []*connection.Pool
+}
+
+func newFixture(t *testing.T, p phase.Phase, origDown bool) *fixture {
+	config.Cfg.General = config.General{MoveHash: true, MoveSet: true, SetToDestWhenGet: true,
+		DegradedWrite: degradedQueue}
+	if err := phase.Set(p); err != nil {
+		t.Fatal(err)
+	}
+	f := &fixture{h: &RedisHandler{Sema: semaphore.New(10, time.Second)}, orig: redistest.New(), dest: redistest.New()}
+	up := false
+	orig := fakePool("origin", f.orig, &origDown)
+	dest := fakePool("destination", f.dest, &up)
+	if origDown {
+		//open origin breaker
+		orig.Get().Close()
+	}
+	f.pools = []*connection.Pool{orig, dest}
+	connection.RedisPoolConnection = &connection.RedisPoolHost{
+		Origin:              orig,
+		Destination:         dest,
+		OriginPipeline:      connection.NewPipeline(orig, 10),
+		DestinationPipeline: connection.NewPipeline(dest, 10),
+	}
+	f.orig.Data["str"] = "a"
+	f.orig.Data["hash"] = map[string]string{"f1": "a", "f2": "b"}
+	f.orig.Data["set"] = map[string]bool{"m1": true, "m2": true}
+	f.dest.Data["dstr"] = "b"
+	return f
+}
+
+// wait for background move and write to give their connection back
+func (f *fixture) checkBorrowed(t *testing.T, name string) {
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		leaked := 0
+		for _, p := range f.pools {
+			leaked += p.Borrowed()
+		}
+		if leaked == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Errorf("%s : %d connection not given back to pool", name, leaked)
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// every path through the handler, whatever its outcome, must give back each connection it took
+func handlerPaths(h *RedisHandler) []struct {
+	name string
+	run  func()
+} {
+	return []struct {
+		name string
+		run  func()
+	}{
+		{"GET source only", func() { h.Get("str") }},
+		{"GET target", func() { h.Get("dstr") }},
+		{"GET missing", func() { h.Get("none") }},
+		{"GET batch", func() { h.GetBatch([]string{"str", "dstr", "none"}) }},
+		{"SET", func() { h.Set("new", []byte("v")) }},
+		{"SETEX", func() { h.Setex("newex", 10, "v") }},
+		{"EXPIRE", func() { h.Expire("dstr", 10) }},
+		{"EXPIRE missing", func() { h.Expire("none", 10) }},
+		{"HEXISTS", func() { h.Hexists("hash", "f1") }},
+		{"HGET", func() { h.Hget("hash", []byte("f2")) }},
+		{"HGETALL", func() { h.Hgetall("hash") }},
+		{"HSET", func() { h.Hset("hash", "f3", []byte("c")) }},
+		{"HSET new", func() { h.Hset("newhash", "f", []byte("c")) }},
+		{"SISMEMBER", func() { h.Sismember("set", "m1") }},
+		{"SMEMBERS", func() { h.Smembers("set") }},
+		{"SADD", func() { h.Sadd("set", []byte("m3")) }},
+		{"SREM", func() { h.Srem("set", []byte("m1")) }},
+		{"DEL", func() { h.Del("str") }},
+		{"DEL missing", func() { h.Del("none") }},
+		{"MOVE", func() { MoveKey("dstr") }},
+		{"REPAIR", func() { RepairKey("hash", false) }},
+		{"INFO", func() { h.Info(nil) }},
+	}
+}
+
+func TestPathsGiveBackConnection(t *testing.T) {
+	for _, p := range []phase.Phase{phase.Shadow, phase.DualWrite, phase.DestPrimary, phase.DestOnly, phase.Rollback} {
+		for _, down := range []bool{false, true} {
+			f := newFixture(t, p, down)
+			for _, path := range handlerPaths(f.h) {
+				path.run()
+				f.checkBorrowed(t, p.String()+" origin down "+strconv.FormatBool(down)+" "+path.name)
+			}
+		}
+	}
+}
+
+func TestReplayGiveBackConnection(t *testing.T) {
+	f := newFixture(t, phase.DestPrimary, false)
+	f.dest.Data["hash"] = map[string]string{"f1": "new"}
+	entries := []journal.Entry{
+		{Action: "delete", Side: "origin", Key: "str", Args: [][]byte{[]byte("str")}},
+		{Action: "duplicate", Side: "origin", Key: "dstr", Cmd: "SET"},
+		{Action: "duplicate", Side: "destination", Key: "none", Cmd: "SET"},
+		{Action: "move", Side: "destination", Key: "hash", Cmd: "HSET"},
+		{Action: "move", Side: "destination", Key: "set", Cmd: "SADD"},
+	}
+	for _, e := range entries {
+		if err := replayWrite(e); err != nil {
+			t.Errorf("replay %s %s : %v", e.Action, e.Key, err)
+		}
+		f.checkBorrowed(t, "replay "+e.Action+" "+e.Key)
+	}
+	//field written to target while source was down win over source
+	if got := f.dest.Data["hash"].(map[string]string); got["f1"] != "new" || got["f2"] != "b" {
+		t.Errorf("got merged hash %v, want f1 kept and f2 moved", got)
+	}
+	if _, ok := f.orig.Data["dstr"]; !ok {
+		t.Error("replay did not copy dstr to origin")
+	}
+}
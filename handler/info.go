@@ -0,0 +1,125 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	rds "github.com/garyburd/redigo/redis"
+	"github.com/tokopedia/redisgrator/config"
+	"github.com/tokopedia/redisgrator/connection"
+	"github.com/tokopedia/redisgrator/metrics"
+	"github.com/tokopedia/redisgrator/phase"
+	"github.com/tokopedia/redisgrator/shadow"
+)
+
+const version = "0.0.1"
+
+// INFO section written in order, each one key:value line per field
+var infoSections = []struct {
+	name  string
+	title string
+	write func(h *RedisHandler, b *strings.Builder)
+}{
+	{"server", "Server", (*RedisHandler).infoServer},
+	{"clients", "Clients", (*RedisHandler).infoClients},
+	{"stats", "Stats", (*RedisHandler).infoStats},
+	{"migration", "Migration", (*RedisHandler).infoMigration},
+	{"keyspace", "Keyspace", (*RedisHandler).infoKeyspace},
+}
+
+// INFO [section]
+func (h *RedisHandler) Info(args [][]byte) ([]byte, error) {
+	section := "default"
+	if len(args) > 0 {
+		section = strings.ToLower(string(args[0]))
+	}
+	all := section == "default" || section == "all" || section == "everything"
+
+	var b strings.Builder
+	for _, s := range infoSections {
+		if !all && s.name != section {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString("\r\n")
+		}
+		b.WriteString("# " + s.title + "\r\n")
+		s.write(h, &b)
+	}
+	if b.Len() == 0 {
+		return nil, errors.New("INFO : unknown section " + section)
+	}
+	return []byte(b.String()), nil
+}
+
+func field(b *strings.Builder, name string, value interface{}) {
+	fmt.Fprintf(b, "%s:%v\r\n", name, value)
+}
+
+func (h *RedisHandler) infoServer(b *strings.Builder) {
+	uptime := int64(time.Since(h.Start).Seconds())
+	field(b, "redisgrator_version", version)
+	field(b, "process_id", os.Getpid())
+	field(b, "tcp_port", config.Cfg.General.Port)
+	field(b, "uptime_in_seconds", uptime)
+	field(b, "uptime_in_days", uptime/86400)
+}
+
+func (h *RedisHandler) infoClients(b *strings.Builder) {
+	field(b, "connected_clients", metrics.ConnectedClients.Value())
+}
+
+func (h *RedisHandler) infoStats(b *strings.Builder) {
+	hits := metrics.Hits.Snapshot()
+	field(b, "total_connections_received", metrics.ConnectionsReceived.Total())
+	field(b, "total_commands_processed", metrics.Commands.Total())
+	field(b, "instantaneous_ops_per_sec", metrics.OpsPerSec())
+	field(b, "hits_origin", hits["origin"])
+	field(b, "hits_destination", hits["destination"])
+	field(b, "upstream_errors", metrics.UpstreamErrors.Total())
+	field(b, "semaphore_timeouts", metrics.SemaTimeouts.Total())
+	field(b, "key_lock_contentions", metrics.LockContentions.Total())
+	field(b, "origin_borrowed_connections", connection.RedisPoolConnection.Origin.Borrowed())
+	field(b, "destination_borrowed_connections", connection.RedisPoolConnection.Destination.Borrowed())
+	field(b, "leaked_connections", metrics.LeakedConnections.Total())
+}
+
+func (h *RedisHandler) infoMigration(b *strings.Builder) {
+	moved := metrics.Moved.Snapshot()
+	field(b, "phase", phase.Current())
+	field(b, "origin_host", config.Cfg.RedisHost.Origin)
+	field(b, "destination_host", config.Cfg.RedisHost.Destination)
+	field(b, "origin_breaker", connection.RedisPoolConnection.Origin.State())
+	field(b, "destination_breaker", connection.RedisPoolConnection.Destination.State())
+	field(b, "degraded_write", degradedWrite())
+	field(b, "keys_moved", metrics.Moved.Total())
+	for _, typ := range []string{"string", "hash", "set", "zset"} {
+		field(b, "keys_moved_"+typ, moved[typ])
+	}
+	for _, up := range []struct {
+		side string
+		pool interface{ Get() rds.Conn }
+	}{
+		{"origin", connection.RedisPoolConnection.Origin},
+		{"destination", connection.RedisPoolConnection.Destination},
+	} {
+		conn := up.pool.Get()
+		n, err := rds.Int64(conn.Do("DBSIZE"))
+		conn.Close()
+		if err == nil {
+			field(b, up.side+"_keys", n)
+		}
+	}
+	field(b, "move_failures", metrics.MoveFailures.Total())
+	field(b, "shadow_read", config.CurrentGeneral().ShadowRead)
+	field(b, "shadow_reads", metrics.ShadowReads.Total())
+	field(b, "shadow_mismatches", metrics.ShadowMismatches.Total())
+	field(b, "shadow_mismatch_rate", fmt.Sprintf("%.4f", shadow.MismatchRate()))
+}
+
+// proxy hold no key itself, so like an empty redis it list no db,
+// key count of each upstream is in Migration section
+func (h *RedisHandler) infoKeyspace(b *strings.Builder) {}
@@ -0,0 +1,162 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	rds "github.com/garyburd/redigo/redis"
+	"github.com/tokopedia/redisgrator/audit"
+	"github.com/tokopedia/redisgrator/config"
+	"github.com/tokopedia/redisgrator/connection"
+	"github.com/tokopedia/redisgrator/journal"
+	"github.com/tokopedia/redisgrator/logger"
+	"github.com/tokopedia/redisgrator/phase"
+	"github.com/tokopedia/redisgrator/rule"
+)
+
+const (
+	// how often journal is checked for entry due to retry
+	journalInterval   = time.Second
+	defaultMaxBackoff = 300
+)
+
+// open journal of failed secondary write and start retrying it in background
+func StartJournal(cfg config.JournalCfg) error {
+	if cfg.File == "" {
+		return nil
+	}
+	if cfg.MaxBackoffSec <= 0 {
+		cfg.MaxBackoffSec = defaultMaxBackoff
+	}
+	if err := journal.Open(cfg.File, time.Duration(cfg.MaxBackoffSec)*time.Second); err != nil {
+		return err
+	}
+	go journal.Run(journalInterval, replayWrite)
+	return nil
+}
+
+// record write that failed on side, args is the whole command arguments
+func journalWrite(action, side, key, cmd string, args []interface{}, err error) {
+	journal.Add(action, side, key, cmd, bytesArgs(args), err)
+}
+
+// bring side that missed a write back in line with the other side, whatever the phase is now,
+// delete of moved key is run again, move skipped while source was down is finished,
+// any other write is repaired by copying the key from the other side so write done after
+// the failed one is never overwritten by a stale replay
+func replayWrite(e journal.Entry) error {
+	switch {
+	case e.Action == audit.ActionMove:
+		return replayMove(e)
+	case e.Action == audit.ActionDelete && len(e.Args) > 0:
+		unlock := lockKey(e.Key)
+		defer unlock()
+		pool := connection.RedisPoolConnection.Origin
+		if e.Side == "destination" {
+			pool = connection.RedisPoolConnection.Destination
+		}
+		conn := pool.Get()
+		defer conn.Close()
+		_, err := conn.Do("DEL", e.Args[0])
+		auditRecord(audit.ActionDelete, "", e.Key, e.Side, 0, -2, err)
+		return err
+	}
+	return replayRepair(e)
+}
+
+// phase routing key from the other side into side
+func towards(side string) phase.Phase {
+	if side == "destination" {
+		return phase.Shadow
+	}
+	return phase.Rollback
+}
+
+// replace key on e.Side with copy from the other side, key gone from the other side is deleted
+func replayRepair(e journal.Entry) error {
+	unlock := lockKey(e.Key)
+	defer unlock()
+	dir := towards(e.Side)
+	srcConn, srcKey := sideConn(nil, dir, true, e.Key, rule.DestKey(e.Key))
+	typ, err := rds.String(srcConn.Do("TYPE", srcKey))
+	srcConn.Close()
+	if err != nil {
+		return errors.New("TYPE : " + err.Error())
+	}
+	if typ == "none" {
+		return repairDelete(nil, dir, e.Key)
+	}
+	if err = replaceKey(nil, dir, e.Key); err != nil {
+		return err
+	}
+	logger.Info("journal : repaired key", "key", logger.Key(e.Key), "target", e.Side)
+	return nil
+}
+
+// move key into e.Side from the other side, member or field already on e.Side
+// was written while the other side was down and is kept
+func replayMove(e journal.Entry) error {
+	unlock := lockKey(e.Key)
+	defer unlock()
+	dir := towards(e.Side)
+	pol := rule.For(e.Key)
+	p := phase.Current()
+	delSrc := !pol.Duplicate && p.DeletesSource() && sideName(p, false) == e.Side
+	if e.Cmd == "SADD" {
+		return transferSet(nil, dir, e.Key, delSrc)
+	}
+	return mergeHash(nil, dir, e.Key, delSrc)
+}
+
+// REDISGRATOR JOURNAL [n]
+// REDISGRATOR JOURNAL DRAIN
+// REDISGRATOR JOURNAL DISCARD <id | ALL>
+func adminJournal(args [][]byte) ([]byte, error) {
+	if !journal.Enabled() {
+		return nil, errors.New("REDISGRATOR JOURNAL : journal is disabled")
+	}
+	limit := 10
+	if len(args) > 0 {
+		switch strings.ToUpper(string(args[0])) {
+		case "DRAIN":
+			done, failed := journal.Retry(true, replayWrite)
+			return []byte(fmt.Sprintf("replayed:%d failed:%d pending:%d", done, failed, journal.Len())), nil
+		case "DISCARD":
+			if len(args) != 2 {
+				return nil, errors.New("REDISGRATOR JOURNAL : wrong number of arguments")
+			}
+			var id uint64
+			if strings.ToUpper(string(args[1])) != "ALL" {
+				n, err := strconv.ParseUint(string(args[1]), 10, 64)
+				if err != nil || n == 0 {
+					return nil, errors.New("REDISGRATOR JOURNAL : invalid id")
+				}
+				id = n
+			}
+			return []byte(strconv.Itoa(journal.Discard(id))), nil
+		}
+		n, err := strconv.Atoi(string(args[0]))
+		if err != nil {
+			return nil, errors.New("REDISGRATOR JOURNAL : invalid limit")
+		}
+		limit = n
+	}
+	entries := journal.Pending()
+	if limit >= 0 && len(entries) > limit {
+		entries = entries[:limit]
+	}
+	var b strings.Builder
+	for _, e := range entries {
+		line, err := json.Marshal(e)
+		if err != nil {
+			return nil, errors.New("REDISGRATOR JOURNAL : " + err.Error())
+		}
+		b.Write(line)
+		b.WriteString("\r\n")
+	}
+	return []byte(b.String()), nil
+}
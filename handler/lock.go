@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tokopedia/redisgrator/metrics"
+)
+
+// number of mutex key lock is striped over
+const lockStripes = 1024
+
+type stripe struct {
+	mu sync.Mutex
+	// goroutine holding or waiting for mu
+	users int32
+}
+
+var stripes [lockStripes]stripe
+
+// key with background move in flight
+var (
+	movingMu sync.Mutex
+	moving   = map[string]bool{}
+)
+
+// lock key so move and write of the same key do not interleave, return unlock func.
+// key lock is not reentrant, function called while holding it must not lock again
+func lockKey(key string) func() {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	s := &stripes[h.Sum32()%lockStripes]
+	if atomic.AddInt32(&s.users, 1) > 1 {
+		metrics.LockContentions.Inc()
+		start := time.Now()
+		s.mu.Lock()
+		metrics.LockWait.Observe(time.Since(start).Seconds())
+	} else {
+		s.mu.Lock()
+	}
+	return func() {
+		s.mu.Unlock()
+		atomic.AddInt32(&s.users, -1)
+	}
+}
+
+// lock key for background move, ok is false when move of key is already in flight
+func lockMove(key string) (unlock func(), ok bool) {
+	movingMu.Lock()
+	if moving[key] {
+		movingMu.Unlock()
+		metrics.MovesDeduplicated.Inc()
+		return nil, false
+	}
+	moving[key] = true
+	movingMu.Unlock()
+
+	unlockKey := lockKey(key)
+	return func() {
+		unlockKey()
+		movingMu.Lock()
+		delete(moving, key)
+		movingMu.Unlock()
+	}, true
+}
+
+// lock key for a write, unlock also wait for room in the worker queue of key once the
+// lock is released, so a full queue never block while holding a lock its worker may need
+func lockWrite(key string) func() {
+	unlock := lockKey(key)
+	return func() {
+		unlock()
+		waitRoom(key)
+	}
+}
@@ -0,0 +1,142 @@
+package handler
+
+import (
+	"hash/fnv"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func stripeOf(key string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return h.Sum32() % lockStripes
+}
+
+// wait for done until timeout, return false when it is not closed in time
+func closedWithin(done <-chan struct{}, timeout time.Duration) bool {
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func TestLockKeyExclusive(t *testing.T) {
+	//counter is not atomic, lost update or race detector report mean two writer held the lock
+	keys := []string{"a", "b", "c"}
+	counts := map[string]*int{}
+	for _, k := range keys {
+		counts[k] = new(int)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		for _, k := range keys {
+			wg.Add(1)
+			go func(k string) {
+				defer wg.Done()
+				unlock := lockKey(k)
+				n := *counts[k]
+				time.Sleep(time.Microsecond)
+				*counts[k] = n + 1
+				unlock()
+			}(k)
+		}
+	}
+	wg.Wait()
+	for _, k := range keys {
+		if *counts[k] != 50 {
+			t.Errorf("key %s : got %d increment, want 50", k, *counts[k])
+		}
+	}
+}
+
+func TestLockKeyStripe(t *testing.T) {
+	other := ""
+	for i := 0; other == ""; i++ {
+		if k := "k" + strconv.Itoa(i); stripeOf(k) != stripeOf("a") {
+			other = k
+		}
+	}
+	unlock := lockKey("a")
+	done := make(chan struct{})
+	go func() {
+		lockKey(other)()
+		close(done)
+	}()
+	if !closedWithin(done, time.Second) {
+		t.Fatal("key on other stripe blocked by held key")
+	}
+
+	done = make(chan struct{})
+	go func() {
+		lockKey("a")()
+		close(done)
+	}()
+	if closedWithin(done, 20*time.Millisecond) {
+		t.Fatal("same key locked twice")
+	}
+	unlock()
+	if !closedWithin(done, time.Second) {
+		t.Fatal("waiter not woken by unlock")
+	}
+}
+
+func TestLockMoveDedup(t *testing.T) {
+	unlock, ok := lockMove("k")
+	if !ok {
+		t.Fatal("first move not granted")
+	}
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	granted := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if u, ok := lockMove("k"); ok {
+				mu.Lock()
+				granted++
+				mu.Unlock()
+				u()
+			}
+		}()
+	}
+	wg.Wait()
+	if granted != 0 {
+		t.Errorf("got %d move granted while one is in flight, want 0", granted)
+	}
+	unlock()
+	unlock, ok = lockMove("k")
+	if !ok {
+		t.Fatal("move not granted once previous move finished")
+	}
+	unlock()
+}
+
+func TestLockWriteWaitMove(t *testing.T) {
+	unlock, ok := lockMove("k")
+	if !ok {
+		t.Fatal("move not granted")
+	}
+	done := make(chan struct{})
+	go func() {
+		lockWrite("k")()
+		close(done)
+	}()
+	if closedWithin(done, 20*time.Millisecond) {
+		t.Fatal("write locked key while move in flight")
+	}
+	unlock()
+	if !closedWithin(done, time.Second) {
+		t.Fatal("write not run once move finished")
+	}
+	//moving flag is cleared with the key lock
+	if unlock, ok := lockMove("k"); !ok {
+		t.Error("move not granted after write")
+	} else {
+		unlock()
+	}
+}
@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"errors"
+
+	rds "github.com/garyburd/redigo/redis"
+	"github.com/tokopedia/redisgrator/phase"
+	"github.com/tokopedia/redisgrator/rule"
+	"github.com/tokopedia/redisgrator/trace"
+)
+
+// move single key from source to target following current phase and key policy,
+// return redis type of the moved key
+func MoveKey(key string) (string, error) {
+	if !rule.For(key).Migrate {
+		return "", errors.New("key is not migrated by policy")
+	}
+	sp := startSpan("REDISGRATOR MOVE", key)
+	defer sp.End()
+	unlock := lockKey(key)
+	defer unlock()
+	return moveKey(sp, key)
+}
+
+// move key like MoveKey, caller must hold key lock
+func moveKey(sp *trace.Span, key string) (string, error) {
+	pol := rule.For(key)
+	p := phase.Current()
+	srcConn, srcKey := sideConn(sp, p, true, key, rule.DestKey(key))
+	typ, err := rds.String(srcConn.Do("TYPE", srcKey))
+	var v []byte
+	if err == nil && typ == "string" {
+		v, err = rds.Bytes(srcConn.Do("GET", srcKey))
+	}
+	//move below take its own connection
+	srcConn.Close()
+	if err != nil {
+		return typ, err
+	}
+
+	switch typ {
+	case "string":
+		err = copyString(sp, p, key, v, !pol.Duplicate && p.DeletesSource())
+		return typ, err
+	case "hash":
+		return typ, moveHash(sp, key)
+	case "set":
+		return typ, moveSet(sp, key)
+	case "zset":
+		return typ, transferZset(sp, p, key, !pol.Duplicate && p.DeletesSource())
+	case "none":
+		return typ, errors.New("key not found in source")
+	}
+	return typ, errors.New("type " + typ + " is not supported")
+}
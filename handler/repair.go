@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"errors"
+
+	"github.com/tokopedia/redisgrator/audit"
+	"github.com/tokopedia/redisgrator/phase"
+	"github.com/tokopedia/redisgrator/rule"
+	"github.com/tokopedia/redisgrator/trace"
+	"github.com/tokopedia/redisgrator/verify"
+)
+
+const (
+	// both side already equal
+	RepairNone = "none"
+	// replace key on losing side with copy of winning side
+	RepairCopy = "copy"
+	// key only exist on source of current phase, move it like MoveKey
+	RepairMigrate = "migrate"
+	// key only exist on losing side and winning side has deleted it
+	RepairDelete = "delete"
+)
+
+type RepairResult struct {
+	Key    string `json:"key"`
+	Action string `json:"action"`
+	// side whose value is kept, origin before cutover and destination after
+	Winner string `json:"winner"`
+	// side changed by the action
+	Target string `json:"target,omitempty"`
+	// mismatch found by verifier before repair
+	Detail string `json:"detail,omitempty"`
+	DryRun bool   `json:"dryRun"`
+	Error  string `json:"error,omitempty"`
+}
+
+// re-verify key and make losing side equal to winning side of current phase,
+// with dryRun only report what would be changed
+func RepairKey(key string, dryRun bool) (RepairResult, error) {
+	res := RepairResult{Key: key, Action: RepairNone, DryRun: dryRun}
+	if !rule.For(key).Migrate {
+		return res, errors.New("key is not migrated by policy")
+	}
+	sp := startSpan("REDISGRATOR REPAIR", key)
+	defer sp.End()
+	//compare and repair without write or move in between
+	unlock := lockKey(key)
+	defer unlock()
+
+	p := phase.Current()
+	// dir route key from winning side to losing side
+	dir := phase.Rollback
+	if p.OriginPrimary() || p == phase.Rollback {
+		dir = phase.Shadow
+	}
+	res.Winner = sideName(dir, true)
+
+	m, err := verify.Key(key)
+	if err == verify.ErrNotFound {
+		return res, nil
+	}
+	if err != nil {
+		return res, err
+	}
+	if m == nil {
+		return res, nil
+	}
+	res.Detail = m.Detail
+	if verify.Expected(p, m) {
+		//key moved off the side current phase delete it from, copying it back would undo the move
+		return res, nil
+	}
+
+	winnerHas := !(m.Kind == verify.KindExtra && res.Winner == "origin") &&
+		!(m.Kind == verify.KindMissing && res.Winner == "destination")
+	switch {
+	case winnerHas:
+		res.Action, res.Target = RepairCopy, sideName(dir, false)
+	case sideName(p, true) == sideName(dir, false):
+		//not migrated yet, losing side is where current phase move key from
+		res.Action, res.Target = RepairMigrate, sideName(p, false)
+	default:
+		res.Action, res.Target = RepairDelete, sideName(dir, false)
+	}
+	if dryRun {
+		return res, nil
+	}
+
+	switch res.Action {
+	case RepairCopy:
+		err = replaceKey(sp, dir, key)
+	case RepairMigrate:
+		_, err = moveKey(sp, key)
+	case RepairDelete:
+		err = repairDelete(sp, dir, key)
+	}
+	sp.SetError(err)
+	if err != nil {
+		res.Error = err.Error()
+	}
+	return res, err
+}
+
+// delete key left on target of dir after source deleted it
+func repairDelete(sp *trace.Span, dir phase.Phase, key string) error {
+	dstConn, dstKey := sideConn(sp, dir, false, key, rule.DestKey(key))
+	defer dstConn.Close()
+
+	ttl := auditTTL(dstConn, dstKey)
+	_, err := dstConn.Do("DEL", dstKey)
+	auditRecord(audit.ActionDelete, "", key, sideName(dir, false), 0, ttl, err)
+	if err != nil {
+		return errors.New("DEL : " + err.Error())
+	}
+	return nil
+}
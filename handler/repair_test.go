@@ -0,0 +1,126 @@
+package handler
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/tokopedia/redisgrator/phase"
+)
+
+func snapshot(data map[string]interface{}) map[string]interface{} {
+	res := make(map[string]interface{}, len(data))
+	for k, v := range data {
+		res[k] = v
+	}
+	return res
+}
+
+func TestRepairKey(t *testing.T) {
+	tests := []struct {
+		phase  phase.Phase
+		orig   string
+		dest   string
+		winner string
+		action string
+		target string
+		// value on each side after repair, empty when deleted
+		wantOrig string
+		wantDest string
+	}{
+		{phase.Shadow, "a", "", "origin", RepairCopy, "destination", "a", "a"},
+		{phase.Shadow, "", "b", "origin", RepairDelete, "destination", "", ""},
+		{phase.Shadow, "a", "b", "origin", RepairCopy, "destination", "a", "a"},
+		{phase.DualWrite, "", "b", "origin", RepairDelete, "destination", "", ""},
+		//key not moved yet is moved like MoveKey, source deleted
+		{phase.DestPrimary, "a", "", "destination", RepairMigrate, "destination", "", "a"},
+		//moved key is deleted from origin
+		{phase.DestPrimary, "", "b", "destination", RepairNone, "", "", "b"},
+		{phase.DestPrimary, "a", "b", "destination", RepairCopy, "origin", "b", "b"},
+		{phase.DestOnly, "a", "", "destination", RepairMigrate, "destination", "", "a"},
+		//origin win again, key not moved back yet is moved back
+		{phase.Rollback, "a", "", "origin", RepairNone, "", "a", ""},
+		{phase.Rollback, "", "b", "origin", RepairMigrate, "origin", "b", ""},
+		{phase.Rollback, "a", "b", "origin", RepairCopy, "destination", "a", "a"},
+	}
+	for _, tt := range tests {
+		for _, dryRun := range []bool{true, false} {
+			name := tt.phase.String() + " origin " + tt.orig + " destination " + tt.dest
+			f := newFixture(t, tt.phase, false)
+			if tt.orig != "" {
+				f.orig.Data["k"] = tt.orig
+			}
+			if tt.dest != "" {
+				f.dest.Data["k"] = tt.dest
+			}
+			origBefore, destBefore := snapshot(f.orig.Data), snapshot(f.dest.Data)
+
+			res, err := RepairKey("k", dryRun)
+			if err != nil {
+				t.Errorf("%s : %v", name, err)
+				continue
+			}
+			if res.Winner != tt.winner || res.Action != tt.action || res.Target != tt.target || res.DryRun != dryRun {
+				t.Errorf("%s : got %+v, want winner %s action %s target %s", name, res, tt.winner, tt.action, tt.target)
+			}
+			f.checkBorrowed(t, name)
+			if dryRun {
+				if !reflect.DeepEqual(f.orig.Data, origBefore) || !reflect.DeepEqual(f.dest.Data, destBefore) {
+					t.Errorf("%s : dry run changed data, got %v %v", name, f.orig.Data, f.dest.Data)
+				}
+				continue
+			}
+			gotOrig, _ := f.orig.Data["k"].(string)
+			gotDest, _ := f.dest.Data["k"].(string)
+			if gotOrig != tt.wantOrig || gotDest != tt.wantDest {
+				t.Errorf("%s : got %q %q after repair, want %q %q", name, gotOrig, gotDest, tt.wantOrig, tt.wantDest)
+			}
+			//nothing left to repair afterwards
+			if res, err := RepairKey("k", false); err != nil || res.Action != RepairNone {
+				t.Errorf("%s : got %+v %v on second repair, want nothing to do", name, res, err)
+			}
+		}
+	}
+}
+
+func TestAdminRepairDryRunPosition(t *testing.T) {
+	tests := []struct {
+		args   []string
+		key    string
+		dryRun bool
+		err    bool
+	}{
+		{[]string{"KEY", "k"}, "k", false, false},
+		{[]string{"KEY", "k", "dryrun"}, "k", true, false},
+		//key named like the option
+		{[]string{"KEY", "DRYRUN"}, "DRYRUN", false, false},
+		{[]string{"KEY", "DRYRUN", "DRYRUN"}, "DRYRUN", true, false},
+		{[]string{"KEY"}, "", false, true},
+		{[]string{"KEY", "k", "NOW"}, "", false, true},
+		{[]string{"KEY", "k", "DRYRUN", "x"}, "", false, true},
+		{[]string{"REPORT", "k"}, "", false, true},
+	}
+	for _, tt := range tests {
+		f := newFixture(t, phase.Shadow, false)
+		f.orig.Data[tt.key] = "a"
+		args := make([][]byte, len(tt.args))
+		for i, a := range tt.args {
+			args[i] = []byte(a)
+		}
+		out, err := adminRepair(args)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%q : got %s, want error", tt.args, out)
+			}
+			continue
+		}
+		var res RepairResult
+		if err != nil || json.Unmarshal(out, &res) != nil {
+			t.Errorf("%q : got %s %v", tt.args, out, err)
+			continue
+		}
+		if res.Key != tt.key || res.DryRun != tt.dryRun {
+			t.Errorf("%q : got key %q dry run %v, want %q %v", tt.args, res.Key, res.DryRun, tt.key, tt.dryRun)
+		}
+	}
+}
@@ -0,0 +1,14 @@
+package handler
+
+import (
+	"github.com/tokopedia/redisgrator/config"
+	"github.com/tokopedia/redisgrator/shadow"
+)
+
+// compare reply of both side when ShadowRead is on, origin reply is still the one served
+func shadowRead(cmd, key string, valOrig, valDest interface{}) {
+	if !config.CurrentGeneral().ShadowRead {
+		return
+	}
+	shadow.Compare(cmd, key, valOrig, valDest)
+}
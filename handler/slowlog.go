@@ -0,0 +1,39 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/tokopedia/redisgrator/slowlog"
+)
+
+// SLOWLOG GET [count] | LEN | RESET
+// like redis SLOWLOG, duration cover both upstream call and synchronous move.
+// server can not reply nested array, so GET entry is one line instead of redis array of field
+func (h *RedisHandler) Slowlog(sub string, args [][]byte) (interface{}, error) {
+	switch strings.ToUpper(sub) {
+	case "GET":
+		n := 10
+		if len(args) > 0 {
+			var err error
+			n, err = strconv.Atoi(string(args[0]))
+			if err != nil {
+				return nil, errors.New("SLOWLOG : count is not an integer")
+			}
+		}
+		entries := []interface{}{}
+		for _, e := range slowlog.Get(n) {
+			entries = append(entries, []byte(fmt.Sprintf("id:%d time:%d duration_us:%d command:%s key:%s",
+				e.ID, e.Time.Unix(), e.Duration.Microseconds(), e.Command, e.Key)))
+		}
+		return entries, nil
+	case "LEN":
+		return slowlog.Len(), nil
+	case "RESET":
+		slowlog.Reset()
+		return "OK", nil
+	}
+	return nil, errors.New("SLOWLOG : unknown subcommand " + sub)
+}
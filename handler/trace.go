@@ -0,0 +1,45 @@
+package handler
+
+import (
+	rds "github.com/garyburd/redigo/redis"
+	"github.com/tokopedia/redisgrator/logger"
+	"github.com/tokopedia/redisgrator/phase"
+	"github.com/tokopedia/redisgrator/trace"
+)
+
+// start root span for proxied command, nil when request is not sampled
+func startSpan(cmd, key string) *trace.Span {
+	sp := trace.Start(cmd)
+	sp.SetAttr("db.system", "redis")
+	sp.SetAttr("db.operation", cmd)
+	sp.SetAttr("redisgrator.key", logger.Key(key))
+	sp.SetAttr("redisgrator.phase", phase.Current().String())
+	return sp
+}
+
+// upstream connection producing child span for every command
+type tracedConn struct {
+	rds.Conn
+	parent *trace.Span
+	side   string
+}
+
+func traced(conn rds.Conn, parent *trace.Span, side string) rds.Conn {
+	if parent == nil {
+		return conn
+	}
+	return tracedConn{Conn: conn, parent: parent, side: side}
+}
+
+func (c tracedConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	sp := c.parent.Child(c.side + " " + cmd)
+	sp.SetAttr("db.system", "redis")
+	sp.SetAttr("db.operation", cmd)
+	sp.SetAttr("redisgrator.upstream", c.side)
+	v, err := c.Conn.Do(cmd, args...)
+	if err != rds.ErrNil {
+		sp.SetError(err)
+	}
+	sp.End()
+	return v, err
+}
@@ -0,0 +1,244 @@
+package handler
+
+import (
+	"errors"
+	"time"
+
+	rds "github.com/garyburd/redigo/redis"
+	"github.com/tokopedia/redisgrator/audit"
+	"github.com/tokopedia/redisgrator/config"
+	"github.com/tokopedia/redisgrator/journal"
+	"github.com/tokopedia/redisgrator/logger"
+	"github.com/tokopedia/redisgrator/metrics"
+	"github.com/tokopedia/redisgrator/phase"
+	"github.com/tokopedia/redisgrator/rule"
+	"github.com/tokopedia/redisgrator/trace"
+)
+
+const (
+	// retry of failed move, every step of a move is idempotent
+	moveRetries = 2
+	// wait before first retry, doubled on each retry
+	moveBackoff = 50 * time.Millisecond
+)
+
+func moveHash(parent *trace.Span, key string) error {
+	pol := rule.For(key)
+	if !pol.Migrate || !pol.MoveHash {
+		return nil
+	}
+	p := phase.Current()
+	if journal.HasKey(key) {
+		//field written to target while source was down is newer than source
+		return mergeHash(parent, p, key, !pol.Duplicate && p.DeletesSource())
+	}
+	return transferHash(parent, p, key, !pol.Duplicate && p.DeletesSource())
+}
+
+func moveSet(parent *trace.Span, set string) error {
+	pol := rule.For(set)
+	if !pol.Migrate || !pol.MoveSet {
+		return nil
+	}
+	p := phase.Current()
+	return transferSet(parent, p, set, !pol.Duplicate && p.DeletesSource())
+}
+
+// copy hash from source to target side of p, delete it from source when delSrc
+func transferHash(parent *trace.Span, p phase.Phase, key string, delSrc bool) error {
+	return traceMove(parent, "hash", key, func(sp *trace.Span) error {
+		return transfer(sp, p, "hash", key, delSrc, false)
+	})
+}
+
+// copy field of hash missing on target side of p from source, field already on target is kept,
+// delete hash from source when delSrc
+func mergeHash(parent *trace.Span, p phase.Phase, key string, delSrc bool) error {
+	return traceMove(parent, "hash", key, func(sp *trace.Span) error {
+		srcConn, srcKey, dstConn, dstKey := route(sp, p, key, rule.DestKey(key))
+		defer srcConn.Close()
+		defer dstConn.Close()
+		src, err := rds.StringMap(srcConn.Do("HGETALL", srcKey))
+		if err != nil {
+			return errors.New("HGETALL : " + err.Error())
+		}
+		dst, err := rds.StringMap(dstConn.Do("HGETALL", dstKey))
+		if err != nil {
+			return errors.New("HGETALL : " + err.Error())
+		}
+		var args []interface{}
+		for field, val := range src {
+			if _, ok := dst[field]; !ok {
+				args = append(args, field, val)
+			}
+		}
+		if len(args) > 0 {
+			return commit(sp, p, srcConn, srcKey, dstConn, dstKey, "hash", key, "HMSET", args, len(args)/2, delSrc, false)
+		}
+		if delSrc && len(src) > 0 {
+			_, err = srcConn.Do("DEL", srcKey)
+			auditRecord(audit.ActionDelete, "hash", key, sideName(p, true), len(src), -2, err)
+			if err != nil {
+				return errors.New("DEL : " + err.Error())
+			}
+		}
+		return nil
+	})
+}
+
+// copy sorted set from source to target side of p, delete it from source when delSrc
+func transferZset(parent *trace.Span, p phase.Phase, key string, delSrc bool) error {
+	return traceMove(parent, "zset", key, func(sp *trace.Span) error {
+		return transfer(sp, p, "zset", key, delSrc, false)
+	})
+}
+
+// copy set from source to target side of p, delete it from source when delSrc
+func transferSet(parent *trace.Span, p phase.Phase, set string, delSrc bool) error {
+	return traceMove(parent, "set", set, func(sp *trace.Span) error {
+		return transfer(sp, p, "set", set, delSrc, false)
+	})
+}
+
+// copy string value from source to target, delete it from source when delSrc
+func copyString(parent *trace.Span, p phase.Phase, key string, val []byte, delSrc bool) error {
+	return traceMove(parent, "string", key, func(sp *trace.Span) error {
+		srcConn, srcKey, dstConn, dstKey := route(sp, p, key, rule.DestKey(key))
+		defer srcConn.Close()
+		defer dstConn.Close()
+		return commit(sp, p, srcConn, srcKey, dstConn, dstKey, "string", key, "SET", []interface{}{val}, len(val), delSrc, false)
+	})
+}
+
+// replace key on target side of p with copy of source, keeping source,
+// stale field, member or different type on target does not survive
+func replaceKey(parent *trace.Span, p phase.Phase, key string) error {
+	srcConn, srcKey := sideConn(parent, p, true, key, rule.DestKey(key))
+	typ, err := rds.String(srcConn.Do("TYPE", srcKey))
+	srcConn.Close()
+	if err != nil {
+		return errors.New("TYPE : " + err.Error())
+	}
+	switch typ {
+	case "hash", "set", "zset":
+		return traceMove(parent, typ, key, func(sp *trace.Span) error {
+			return transfer(sp, p, typ, key, false, true)
+		})
+	case "string":
+		return traceMove(parent, typ, key, func(sp *trace.Span) error {
+			srcConn, srcKey, dstConn, dstKey := route(sp, p, key, rule.DestKey(key))
+			defer srcConn.Close()
+			defer dstConn.Close()
+			val, err := rds.Bytes(srcConn.Do("GET", srcKey))
+			if err != nil {
+				return errors.New("GET : " + err.Error())
+			}
+			return commit(sp, p, srcConn, srcKey, dstConn, dstKey, typ, key, "SET", []interface{}{val}, len(val), false, true)
+		})
+	}
+	return errors.New("type " + typ + " is not supported")
+}
+
+// run move in its own span with retry, counting failure once all attempt failed
+func traceMove(parent *trace.Span, typ, key string, move func(sp *trace.Span) error) (err error) {
+	sp := parent.Child("move " + typ)
+	sp.SetAttr("redisgrator.key", logger.Key(key))
+	defer func() {
+		if err != nil {
+			metrics.MoveFailures.Inc(typ)
+		}
+		sp.SetError(err)
+		sp.End()
+	}()
+	for attempt := 0; ; attempt++ {
+		err = move(sp)
+		if err == nil || attempt == moveRetries {
+			return err
+		}
+		logger.Warn("move failed, retrying", "type", typ, "key", logger.Key(key), "attempt", attempt+1, "err", err)
+		time.Sleep(moveBackoff << uint(attempt))
+	}
+}
+
+// read whole hash or set from source then commit it to target
+func transfer(sp *trace.Span, p phase.Phase, typ, key string, delSrc, replace bool) error {
+	srcConn, srcKey, dstConn, dstKey := route(sp, p, key, rule.DestKey(key))
+	defer srcConn.Close()
+	defer dstConn.Close()
+	c := collections[typ]
+
+	if threshold := config.CurrentGeneral().BigKeyThreshold; threshold > 0 {
+		n, err := rds.Int(srcConn.Do(c.lenCmd, srcKey))
+		if err != nil {
+			return errors.New(c.lenCmd + " : " + err.Error())
+		}
+		if n > threshold {
+			return transferChunked(sp, p, srcConn, srcKey, dstConn, dstKey, typ, key, delSrc, replace)
+		}
+	}
+
+	vals, err := rds.Values(srcConn.Do(c.readCmd, append([]interface{}{srcKey}, c.readArgs...)...))
+	if err != nil {
+		return errors.New(c.readCmd + " : " + err.Error())
+	}
+	if len(vals) == 0 {
+		//gone from source, nothing to move
+		return nil
+	}
+	return commit(sp, p, srcConn, srcKey, dstConn, dstKey, typ, key, c.writeCmd, writeArgs(typ, vals), len(vals)/c.step, delSrc, replace)
+}
+
+// write whole value to target together with source ttl in one MULTI/EXEC so reader never see
+// partial value, source is deleted only after target write is confirmed.
+// with replace existing target key is dropped in the same transaction instead of merged
+func commit(sp *trace.Span, p phase.Phase, srcConn rds.Conn, srcKey string, dstConn rds.Conn, dstKey string,
+	typ, key, writeCmd string, args []interface{}, size int, delSrc, replace bool) error {
+	pttl, err := rds.Int64(srcConn.Do("PTTL", srcKey))
+	if err != nil {
+		return errors.New("PTTL : " + err.Error())
+	}
+	ttl := pttl
+	if pttl > 0 {
+		ttl = pttl / 1000
+	}
+
+	err = execWrite(dstConn, writeCmd, dstKey, args, pttl, replace)
+	auditRecord(audit.ActionMove, typ, key, sideName(p, false), size, ttl, err)
+	if err != nil {
+		return errors.New(writeCmd + " : " + err.Error())
+	}
+	metrics.Moved.Inc(typ)
+	logger.Debug("move "+typ, "key", logger.Key(dstKey), "size", size)
+
+	if delSrc {
+		_, err = srcConn.Do("DEL", srcKey)
+		auditRecord(audit.ActionDelete, typ, key, sideName(p, true), size, ttl, err)
+		if err != nil {
+			return errors.New("DEL : " + err.Error())
+		}
+		logger.Debug("move "+typ+" delete source", "key", logger.Key(srcKey))
+	}
+	return nil
+}
+
+// MULTI, DEL key when replace, cmd key args..., PEXPIRE key pttl when pttl > 0, EXEC
+func execWrite(conn rds.Conn, cmd, key string, args []interface{}, pttl int64, replace bool) error {
+	conn.Send("MULTI")
+	if replace {
+		conn.Send("DEL", key)
+	}
+	conn.Send(cmd, append([]interface{}{key}, args...)...)
+	if pttl > 0 {
+		conn.Send("PEXPIRE", key, pttl)
+	}
+	replies, err := rds.Values(conn.Do("EXEC"))
+	if err != nil {
+		return err
+	}
+	for _, r := range replies {
+		if e, ok := r.(rds.Error); ok {
+			return e
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package handler
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/tokopedia/redisgrator/phase"
+	"github.com/tokopedia/redisgrator/redistest"
+)
+
+func TestExecWrite(t *testing.T) {
+	tests := []struct {
+		name    string
+		pttl    int64
+		replace bool
+		want    []string
+	}{
+		{"merge", 0, false, []string{"MULTI", "HMSET k f v", "EXEC"}},
+		{"with ttl", 5000, false, []string{"MULTI", "HMSET k f v", "PEXPIRE k 5000", "EXEC"}},
+		{"replace", 5000, true, []string{"MULTI", "DEL k", "HMSET k f v", "PEXPIRE k 5000", "EXEC"}},
+	}
+	for _, tt := range tests {
+		r := redistest.New()
+		if err := execWrite(redistest.NewConn(r), "HMSET", "k", []interface{}{"f", "v"}, tt.pttl, tt.replace); err != nil {
+			t.Errorf("%s : %v", tt.name, err)
+		}
+		if got := r.Calls(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s : got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	//error of a queued command fail the write
+	r := redistest.New()
+	r.FailNext("HMSET", 1)
+	if err := execWrite(redistest.NewConn(r), "HMSET", "k", []interface{}{"f", "v"}, 0, false); err != redistest.ErrInjected {
+		t.Errorf("got %v for failed queued command, want it reported", err)
+	}
+}
+
+func TestCommitExecFailure(t *testing.T) {
+	tests := []struct {
+		name string
+		// EXEC failing before one succeed, negative fail every attempt
+		fail int
+		// EXEC sent by traceMove attempts
+		execs int
+		moved bool
+	}{
+		{"first attempt", 0, 1, true},
+		{"retried", 1, 2, true},
+		{"every attempt", -1, moveRetries + 1, false},
+	}
+	for _, tt := range tests {
+		f := newFixture(t, phase.DestPrimary, false)
+		f.dest.FailNext("EXEC", tt.fail)
+		f.dest.Calls()
+		err := transferHash(nil, phase.DestPrimary, "hash", true)
+		if (err == nil) != tt.moved {
+			t.Errorf("%s : got %v", tt.name, err)
+		}
+		if got := calls(f.dest.Calls(), "EXEC"); len(got) != tt.execs {
+			t.Errorf("%s : got %d EXEC, want %d", tt.name, len(got), tt.execs)
+		}
+		hash := map[string]string{"f1": "a", "f2": "b"}
+		if tt.moved {
+			if _, ok := f.orig.Data["hash"]; ok || !reflect.DeepEqual(f.dest.Data["hash"], hash) {
+				t.Errorf("%s : got source %v target %v, want hash moved", tt.name, f.orig.Data["hash"], f.dest.Data["hash"])
+			}
+		} else {
+			//source is kept until target write is confirmed
+			if !reflect.DeepEqual(f.orig.Data["hash"], hash) || f.dest.Data["hash"] != nil {
+				t.Errorf("%s : got source %v target %v, want source intact", tt.name, f.orig.Data["hash"], f.dest.Data["hash"])
+			}
+			if got := calls(f.orig.Calls(), "DEL"); len(got) != 0 {
+				t.Errorf("%s : got %q on source after failed EXEC", tt.name, got)
+			}
+		}
+		f.checkBorrowed(t, tt.name)
+	}
+}
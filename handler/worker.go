@@ -0,0 +1,206 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+
+	rds "github.com/garyburd/redigo/redis"
+	"github.com/tokopedia/redisgrator/audit"
+	"github.com/tokopedia/redisgrator/config"
+	"github.com/tokopedia/redisgrator/connection"
+	"github.com/tokopedia/redisgrator/logger"
+	"github.com/tokopedia/redisgrator/phase"
+	"github.com/tokopedia/redisgrator/rule"
+	"github.com/tokopedia/redisgrator/trace"
+	"github.com/tokopedia/redisgrator/worker"
+)
+
+// kind of background task
+const (
+	// lazy move of hash or set found only in source, Cmd is the redis type
+	taskMove = "move"
+	// lazy copy of string found only in source, Args is the value read
+	taskCopy = "copy"
+	// write mirrored to destination while origin is primary, Cmd and Args is the write
+	taskMirror = "mirror"
+	// Duplicate write or delete of source side, Args include key name on that side
+	taskSource = "source"
+)
+
+const (
+	defaultWorkers   = 16
+	defaultQueueSize = 1024
+)
+
+var jobs *worker.Pool
+
+// start worker pool running lazy move and mirrored write
+func StartWorkers(cfg config.WorkerCfg) error {
+	if cfg.Workers == 0 {
+		cfg.Workers = defaultWorkers
+	}
+	if cfg.QueueSize == 0 {
+		cfg.QueueSize = defaultQueueSize
+	}
+	if cfg.Overflow == "" {
+		cfg.Overflow = worker.Drop
+	}
+	p, err := worker.New(cfg.Workers, cfg.QueueSize, cfg.Overflow, cfg.SpillFile, runTask)
+	if err != nil {
+		return err
+	}
+	jobs = p
+	return nil
+}
+
+func submit(t worker.Task) {
+	if jobs == nil {
+		go runTask(t)
+		return
+	}
+	if !jobs.Submit(t) {
+		logger.Error("worker queue full, task dropped", "kind", t.Kind, "cmd", t.Cmd, "key", logger.Key(t.Key))
+		if t.Kind == taskMirror || t.Kind == taskSource {
+			journalTask(t, errors.New("worker queue full"))
+		}
+	}
+}
+
+// with Block overflow wait until queue of key has room, caller must not hold the key lock
+func waitRoom(key string) {
+	if jobs != nil {
+		jobs.WaitRoom(key)
+	}
+}
+
+// queue move of hash or set from source to target
+func submitMove(sp *trace.Span, typ, key string) {
+	submit(worker.Task{Kind: taskMove, Key: key, Cmd: typ, Dedup: true, Span: sp})
+	waitRoom(key)
+}
+
+func runTask(t worker.Task) {
+	var err error
+	switch t.Kind {
+	case taskMove, taskCopy:
+		unlock, ok := lockMove(t.Key)
+		if !ok {
+			return
+		}
+		defer unlock()
+		err = runMove(t)
+	case taskMirror:
+		//handler released key lock already, keep move and write in order
+		unlock := lockKey(t.Key)
+		defer unlock()
+		err = mirrorWrite(t.Span, t.Cmd, t.Key, interfaceArgs(t.Args)...)
+		if err != nil {
+			journalTask(t, err)
+		}
+	case taskSource:
+		pipeSourceWrite(t)
+	default:
+		err = errors.New("unknown task " + t.Kind)
+	}
+	if err != nil {
+		logger.Error("err when run background "+t.Kind, "cmd", t.Cmd, "key", logger.Key(t.Key), "err", err)
+	}
+}
+
+func runMove(t worker.Task) error {
+	switch {
+	case t.Kind == taskMove && t.Cmd == "hash":
+		return moveHash(t.Span, t.Key)
+	case t.Kind == taskMove && t.Cmd == "set":
+		return moveSet(t.Span, t.Key)
+	case t.Kind == taskCopy && len(t.Args) == 1:
+		pol := rule.For(t.Key)
+		p := phase.Current()
+		//value read before queued is stale once target got written meanwhile
+		dstConn, dstKey := sideConn(t.Span, p, false, t.Key, rule.DestKey(t.Key))
+		n, err := rds.Int(dstConn.Do("EXISTS", dstKey))
+		dstConn.Close()
+		if err != nil || n > 0 {
+			return err
+		}
+		return copyString(t.Span, p, t.Key, t.Args[0], !pol.Duplicate && p.DeletesSource())
+	}
+	return errors.New("unknown move " + t.Cmd)
+}
+
+// background write missed by its side
+func journalTask(t worker.Task, err error) {
+	if t.Kind == taskSource {
+		journalWrite(t.Action, t.Side, t.Key, t.Cmd, interfaceArgs(t.Args), err)
+		return
+	}
+	args := append([]interface{}{rule.DestKey(t.Key)}, interfaceArgs(t.Args)...)
+	journalWrite(audit.ActionDuplicate, "destination", t.Key, t.Cmd, args, err)
+}
+
+// send source write through pipeline of its side, outcome is logged and audited,
+// ttl recorded in audit is read before delete and after any other write
+func pipeSourceWrite(t worker.Task) {
+	pipe := connection.RedisPoolConnection.OriginPipeline
+	if t.Side == "destination" {
+		pipe = connection.RedisPoolConnection.DestinationPipeline
+	}
+	args := interfaceArgs(t.Args)
+	// callback run one after another in pipeline order
+	var werr error
+	var ttl int64
+	write := func(_ interface{}, err error) {
+		werr = err
+		if err != nil {
+			logger.Error(t.Cmd+" : err when write "+t.Action+" to source", "err", err)
+			journalTask(t, err)
+		}
+	}
+	readTTL := func(reply interface{}, err error) {
+		ttl, _ = rds.Int64(reply, err)
+	}
+	record := func() {
+		auditRecord(t.Action, t.Type, t.Key, t.Side, t.Size, ttl, werr)
+	}
+	if !audit.Enabled() {
+		pipe.Go(write, t.Cmd, args...)
+		return
+	}
+	if t.Action == audit.ActionDelete {
+		pipe.Go(readTTL, "TTL", args[0])
+		pipe.Go(func(reply interface{}, err error) {
+			write(reply, err)
+			record()
+		}, t.Cmd, args...)
+		return
+	}
+	pipe.Go(write, t.Cmd, args...)
+	pipe.Go(func(reply interface{}, err error) {
+		readTTL(reply, err)
+		record()
+	}, "TTL", args[0])
+}
+
+func interfaceArgs(b [][]byte) []interface{} {
+	args := make([]interface{}, len(b))
+	for i, a := range b {
+		args[i] = a
+	}
+	return args
+}
+
+// command arguments as bytes, like redis receive them
+func bytesArgs(args []interface{}) [][]byte {
+	b := make([][]byte, len(args))
+	for i, a := range args {
+		switch v := a.(type) {
+		case []byte:
+			b[i] = v
+		case string:
+			b[i] = []byte(v)
+		default:
+			b[i] = []byte(fmt.Sprint(v))
+		}
+	}
+	return b
+}
@@ -0,0 +1,337 @@
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/tokopedia/redisgrator/logger"
+	"github.com/tokopedia/redisgrator/metrics"
+)
+
+// record kept in journal file, pending entry is rebuilt from them on open
+const (
+	opAdd   = "add"
+	opRetry = "retry"
+	opDone  = "done"
+	// highest id given so far, first record of a compacted file
+	opLastID = "last"
+)
+
+// record count above which file is compacted once most record are dead
+const compactAfter = 1000
+
+// wait before first retry, doubled on each failed retry up to max backoff
+const firstBackoff = time.Second
+
+// write that reached primary side but failed on the other side
+type Entry struct {
+	ID   uint64    `json:"id"`
+	Time time.Time `json:"time"`
+	// audit action of the write, duplicate, delete or move
+	Action string `json:"action,omitempty"`
+	// side that missed the write, origin or destination
+	Side string `json:"side,omitempty"`
+	Key  string `json:"key,omitempty"`
+	Cmd  string `json:"cmd,omitempty"`
+	// whole command arguments, first one is key name on that side
+	Args      [][]byte  `json:"args,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	Attempts  int       `json:"attempts"`
+	NextRetry time.Time `json:"nextRetry"`
+}
+
+type record struct {
+	Op string `json:"op"`
+	Entry
+}
+
+type journal struct {
+	mu   sync.Mutex
+	path string
+	f    *os.File
+	// record in file, live one are the pending entry and the last id
+	records    int
+	lastID     uint64
+	pending    map[uint64]*Entry
+	maxBackoff time.Duration
+}
+
+var current *journal
+
+// open journal file, entry still pending from previous run is retried again
+func Open(path string, maxBackoff time.Duration) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	j := &journal{path: path, f: f, pending: make(map[uint64]*Entry), maxBackoff: maxBackoff}
+	if err = j.load(); err != nil {
+		f.Close()
+		return err
+	}
+	j.maybeCompact()
+	for range j.pending {
+		metrics.JournalPending.Inc()
+	}
+	current = j
+	return nil
+}
+
+func Enabled() bool {
+	return current != nil
+}
+
+// record failed write, failure to record is only logged like audit
+func Add(action, side, key, cmd string, args [][]byte, werr error) {
+	metrics.JournalRecorded.Inc(side)
+	if current == nil {
+		return
+	}
+	j := current
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.lastID++
+	now := time.Now()
+	e := &Entry{ID: j.lastID, Time: now, Action: action, Side: side, Key: key, Cmd: cmd, Args: args, NextRetry: now.Add(firstBackoff)}
+	if werr != nil {
+		e.Error = werr.Error()
+	}
+	if err := j.append(opAdd, *e); err != nil {
+		logger.Error("journal : err when record write", "key", logger.Key(key), "err", err)
+		return
+	}
+	j.pending[e.ID] = e
+	metrics.JournalPending.Inc()
+}
+
+// pending entry ordered by id
+func Pending() []Entry {
+	if current == nil {
+		return nil
+	}
+	current.mu.Lock()
+	defer current.mu.Unlock()
+	return current.sorted(false, time.Time{})
+}
+
+func Len() int {
+	if current == nil {
+		return 0
+	}
+	current.mu.Lock()
+	defer current.mu.Unlock()
+	return len(current.pending)
+}
+
+// true while an entry of key is still pending
+func HasKey(key string) bool {
+	if current == nil {
+		return false
+	}
+	current.mu.Lock()
+	defer current.mu.Unlock()
+	for _, e := range current.pending {
+		if e.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// retry every entry whose backoff elapsed, meant to run in its own goroutine
+func Run(interval time.Duration, apply func(Entry) error) {
+	for range time.Tick(interval) {
+		Retry(false, apply)
+	}
+}
+
+// retry entry due now, or every pending entry when all, entry applied successfully is removed
+func Retry(all bool, apply func(Entry) error) (done, failed int) {
+	if current == nil {
+		return 0, 0
+	}
+	j := current
+	j.mu.Lock()
+	entries := j.sorted(!all, time.Now())
+	j.mu.Unlock()
+
+	for _, e := range entries {
+		err := apply(e)
+		j.mu.Lock()
+		if err == nil {
+			j.finish(e.ID)
+			metrics.JournalReplayed.Inc("ok")
+			done++
+		} else {
+			j.retried(e.ID, err)
+			metrics.JournalReplayed.Inc("error")
+			failed++
+		}
+		j.mu.Unlock()
+	}
+	return done, failed
+}
+
+// drop pending entry without retry, every entry when id is 0
+func Discard(id uint64) int {
+	if current == nil {
+		return 0
+	}
+	j := current
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	n := 0
+	for _, e := range j.sorted(false, time.Time{}) {
+		if id == 0 || e.ID == id {
+			j.finish(e.ID)
+			n++
+		}
+	}
+	return n
+}
+
+// copy of pending entry, only those due at now when dueOnly, lock must be held
+func (j *journal) sorted(dueOnly bool, now time.Time) []Entry {
+	entries := make([]Entry, 0, len(j.pending))
+	for _, e := range j.pending {
+		if dueOnly && e.NextRetry.After(now) {
+			continue
+		}
+		entries = append(entries, *e)
+	}
+	sort.Slice(entries, func(a, b int) bool { return entries[a].ID < entries[b].ID })
+	return entries
+}
+
+// lock must be held
+func (j *journal) finish(id uint64) {
+	if _, ok := j.pending[id]; !ok {
+		return
+	}
+	if err := j.append(opDone, Entry{ID: id}); err != nil {
+		logger.Error("journal : err when mark entry done", "id", id, "err", err)
+	}
+	delete(j.pending, id)
+	metrics.JournalPending.Dec()
+	j.maybeCompact()
+}
+
+// lock must be held
+func (j *journal) retried(id uint64, err error) {
+	e, ok := j.pending[id]
+	if !ok {
+		return
+	}
+	e.Attempts++
+	e.Error = err.Error()
+	backoff := firstBackoff << uint(e.Attempts)
+	if backoff > j.maxBackoff || backoff <= 0 {
+		backoff = j.maxBackoff
+	}
+	e.NextRetry = time.Now().Add(backoff)
+	if werr := j.append(opRetry, Entry{ID: id, Error: e.Error, Attempts: e.Attempts, NextRetry: e.NextRetry}); werr != nil {
+		logger.Error("journal : err when record retry", "id", id, "err", werr)
+	}
+	//entry retried forever must not grow the file forever
+	j.maybeCompact()
+}
+
+// lock must be held
+func (j *journal) append(op string, e Entry) error {
+	if err := write(j.f, op, e); err != nil {
+		return err
+	}
+	j.records++
+	return nil
+}
+
+func write(w io.Writer, op string, e Entry) error {
+	b, err := json.Marshal(record{Op: op, Entry: e})
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	_, err = w.Write(b)
+	return err
+}
+
+// compact once drained, or once dead record outnumber live one in a big file, lock must be held
+func (j *journal) maybeCompact() {
+	live := len(j.pending) + 1
+	if j.records <= live || (len(j.pending) > 0 && (j.records < compactAfter || j.records < 2*live)) {
+		return
+	}
+	if err := j.compact(); err != nil {
+		logger.Error("journal : err when compact", "err", err)
+	}
+}
+
+// rewrite file with last id and pending entry only, the new file replace the old one by rename
+// so a crash leave either of them whole. lock must be held
+func (j *journal) compact() error {
+	tmp := j.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	//id keep growing across compaction and restart so JOURNAL DISCARD never hit a new entry by old id
+	err = write(w, opLastID, Entry{ID: j.lastID})
+	for _, e := range j.sorted(false, time.Time{}) {
+		if err == nil {
+			err = write(w, opAdd, e)
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmp, j.path)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	//renamed file is the journal now, keep appending to it
+	j.f.Close()
+	j.f = f
+	j.records = len(j.pending) + 1
+	return nil
+}
+
+func (j *journal) load() error {
+	sc := bufio.NewScanner(j.f)
+	sc.Buffer(make([]byte, 64*1024), 512*1024*1024)
+	for sc.Scan() {
+		var r record
+		//partially written line of a crash is skipped
+		if json.Unmarshal(sc.Bytes(), &r) != nil {
+			continue
+		}
+		j.records++
+		if r.ID > j.lastID {
+			j.lastID = r.ID
+		}
+		switch r.Op {
+		case opAdd:
+			e := r.Entry
+			j.pending[e.ID] = &e
+		case opRetry:
+			if e, ok := j.pending[r.ID]; ok {
+				e.Attempts, e.Error, e.NextRetry = r.Attempts, r.Error, r.NextRetry
+			}
+		case opDone:
+			delete(j.pending, r.ID)
+		}
+	}
+	return sc.Err()
+}
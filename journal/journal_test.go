@@ -0,0 +1,192 @@
+package journal
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// open journal at path, closing the one opened before
+func reopen(t *testing.T, path string, maxBackoff time.Duration) {
+	if current != nil {
+		current.f.Close()
+		current = nil
+	}
+	if err := Open(path, maxBackoff); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if current != nil {
+			current.f.Close()
+			current = nil
+		}
+	})
+}
+
+func add(keys ...string) {
+	for _, k := range keys {
+		Add("duplicate", "destination", k, "SET", [][]byte{[]byte(k), []byte("v")}, errors.New("down"))
+	}
+}
+
+func pendingKeys() []string {
+	var keys []string
+	for _, e := range Pending() {
+		keys = append(keys, e.Key)
+	}
+	return keys
+}
+
+func TestLoadKeepPending(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+	reopen(t, path, time.Minute)
+	add("a", "b", "c")
+	done, failed := Retry(true, func(e Entry) error {
+		if e.Key == "b" {
+			return errors.New("still down")
+		}
+		return nil
+	})
+	if done != 2 || failed != 1 {
+		t.Fatalf("got done %d failed %d, want 2 and 1", done, failed)
+	}
+
+	reopen(t, path, time.Minute)
+	got := Pending()
+	if len(got) != 1 || got[0].Key != "b" || got[0].ID != 2 {
+		t.Fatalf("got %+v, want only entry 2 of key b", got)
+	}
+	if got[0].Attempts != 1 || got[0].Error != "still down" || string(got[0].Args[0]) != "b" {
+		t.Errorf("retry state not restored, got %+v", got[0])
+	}
+	add("d")
+	if e := Pending()[1]; e.ID != 4 {
+		t.Errorf("got id %d after reopen, want 4", e.ID)
+	}
+}
+
+func TestLoadSkipPartialLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+	reopen(t, path, time.Minute)
+	add("a")
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"op":"add","id":2,"key":"b"`)
+	f.Close()
+
+	reopen(t, path, time.Minute)
+	if got := pendingKeys(); len(got) != 1 || got[0] != "a" {
+		t.Fatalf("got %v, want [a]", got)
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	reopen(t, filepath.Join(t.TempDir(), "journal"), 3*time.Second)
+	add("a")
+	calls := 0
+	fail := func(Entry) error {
+		calls++
+		return errors.New("down")
+	}
+	if done, failed := Retry(false, fail); done != 0 || failed != 0 {
+		t.Fatalf("entry retried before first backoff elapsed")
+	}
+
+	tests := []struct {
+		attempts int
+		backoff  time.Duration
+	}{
+		{1, 2 * time.Second},
+		{2, 3 * time.Second},
+		{3, 3 * time.Second},
+	}
+	for _, tt := range tests {
+		before := time.Now()
+		Retry(true, fail)
+		e := Pending()[0]
+		if e.Attempts != tt.attempts {
+			t.Fatalf("got attempts %d, want %d", e.Attempts, tt.attempts)
+		}
+		if wait := e.NextRetry.Sub(before); wait < tt.backoff || wait > tt.backoff+time.Second {
+			t.Errorf("attempt %d : got backoff %v, want %v", tt.attempts, wait, tt.backoff)
+		}
+	}
+	if calls != 3 {
+		t.Errorf("got %d calls, want 3", calls)
+	}
+}
+
+func records(t *testing.T, path string) []string {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+}
+
+func TestCompactOnceDrained(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+	reopen(t, path, time.Minute)
+	add("a", "b")
+	Discard(1)
+	if got := records(t, path); len(got) != 3 {
+		t.Fatalf("got %d record, want journal kept while entry still pending", len(got))
+	}
+	if !HasKey("b") || HasKey("a") {
+		t.Fatal("discarded entry still pending")
+	}
+
+	Retry(true, func(Entry) error { return nil })
+	if got := records(t, path); len(got) != 1 || !strings.Contains(got[0], `"op":"last","id":2`) {
+		t.Fatalf("got %q once drained, want only the last id", got)
+	}
+	//id keep growing after compaction and restart so JOURNAL DISCARD never hit a new entry by old id
+	reopen(t, path, time.Minute)
+	add("c")
+	if e := Pending()[0]; e.ID != 3 {
+		t.Errorf("got id %d after compaction, want 3", e.ID)
+	}
+	reopen(t, path, time.Minute)
+	if got := pendingKeys(); len(got) != 1 || got[0] != "c" {
+		t.Fatalf("got %v after reopen, want [c]", got)
+	}
+}
+
+func TestCompactStuckEntry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+	reopen(t, path, time.Minute)
+	add("stuck")
+	fail := func(e Entry) error {
+		if e.Key == "stuck" {
+			return errors.New("still down")
+		}
+		return nil
+	}
+	for i := 0; i < compactAfter; i++ {
+		add("k" + strconv.Itoa(i))
+		Retry(true, fail)
+	}
+	//every add, retry and done record would be kept without compaction
+	if got := records(t, path); len(got) >= compactAfter {
+		t.Fatalf("got %d record with one entry pending, want file compacted", len(got))
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary file left after compaction : %v", err)
+	}
+
+	reopen(t, path, time.Minute)
+	got := Pending()
+	if len(got) != 1 || got[0].ID != 1 || got[0].Attempts != compactAfter || got[0].Error != "still down" {
+		t.Fatalf("got %+v after reopen, want stuck entry with its retry state", got)
+	}
+	add("new")
+	if e := Pending()[1]; e.ID != compactAfter+2 {
+		t.Errorf("got id %d after reopen, want %d", e.ID, compactAfter+2)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net"
+	"sync"
+
+	"github.com/tokopedia/redisgrator/batch"
+	"github.com/tokopedia/redisgrator/config"
+	"github.com/tokopedia/redisgrator/handler"
+	"github.com/tokopedia/redisgrator/metrics"
+)
+
+// listener keeping track of connected clients for INFO and metrics
+type countListener struct {
+	net.Listener
+	h *handler.RedisHandler
+}
+
+func (l countListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	metrics.ConnectionsReceived.Inc()
+	metrics.ConnectedClients.Inc()
+	if config.Cfg.General.BatchPipeline {
+		c = batch.NewConn(c, l.h)
+	}
+	if config.Cfg.General.AdminPassword != "" {
+		c = handler.NewAdminConn(c)
+	}
+	return &countConn{Conn: c}, nil
+}
+
+type countConn struct {
+	net.Conn
+	once sync.Once
+}
+
+func (c *countConn) Close() error {
+	c.once.Do(metrics.ConnectedClients.Dec)
+	return c.Conn.Close()
+}
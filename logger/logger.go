@@ -0,0 +1,191 @@
+package logger
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Level int32
+
+const (
+	DebugLevel Level = iota
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < 0 || int(l) >= len(levelNames) {
+		return "unknown"
+	}
+	return levelNames[l]
+}
+
+func ParseLevel(name string) (Level, error) {
+	name = strings.ToLower(name)
+	for i, n := range levelNames {
+		if n == name {
+			return Level(i), nil
+		}
+	}
+	return 0, errors.New("unknown log level " + name)
+}
+
+type Options struct {
+	Level Level
+	JSON  bool
+	// replace logged value with its size
+	RedactValues bool
+	// key matching this function is logged as hash instead of name
+	RedactKey func(key string) bool
+	// log 1 of n command, keyed by upper case command name
+	Sample map[string]int
+}
+
+var (
+	opts = Options{Level: InfoLevel}
+	// command counter used for sampling, fixed after Init
+	sampled = map[string]*uint64{}
+	mu      sync.Mutex
+)
+
+// set logger options, must be called before serving request
+func Init(o Options) {
+	opts = o
+	//command is looked up upper case, rate below 1 mean every command is logged
+	opts.Sample = make(map[string]int, len(o.Sample))
+	sampled = make(map[string]*uint64, len(o.Sample))
+	for cmd, n := range o.Sample {
+		if n > 1 {
+			opts.Sample[strings.ToUpper(cmd)] = n
+			sampled[strings.ToUpper(cmd)] = new(uint64)
+		}
+	}
+}
+
+// parse sampling rule written as "<COMMAND> <n>"
+func ParseSample(rules []string) (map[string]int, error) {
+	res := make(map[string]int, len(rules))
+	for _, r := range rules {
+		parts := strings.Fields(r)
+		if len(parts) != 2 {
+			return nil, errors.New("invalid sample rule " + r)
+		}
+		n, err := strconv.Atoi(parts[1])
+		if err != nil || n < 1 {
+			return nil, errors.New("invalid sample rate in rule " + r)
+		}
+		res[strings.ToUpper(parts[0])] = n
+	}
+	return res, nil
+}
+
+func Debug(msg string, kv ...interface{}) { write(DebugLevel, msg, kv) }
+func Info(msg string, kv ...interface{})  { write(InfoLevel, msg, kv) }
+func Warn(msg string, kv ...interface{})  { write(WarnLevel, msg, kv) }
+func Error(msg string, kv ...interface{}) { write(ErrorLevel, msg, kv) }
+
+// log at error level then exit
+func Fatal(msg string, kv ...interface{}) {
+	write(ErrorLevel, msg, kv)
+	os.Exit(1)
+}
+
+// log proxied command at debug level, sampled per command and key redacted
+func Command(cmd, key string, kv ...interface{}) {
+	if opts.Level > DebugLevel {
+		return
+	}
+	if n, ok := sampled[cmd]; ok {
+		if (atomic.AddUint64(n, 1)-1)%uint64(opts.Sample[cmd]) != 0 {
+			return
+		}
+	}
+	write(DebugLevel, cmd, append([]interface{}{"key", Key(key)}, kv...))
+}
+
+// key name safe to log
+func Key(key string) string {
+	if opts.RedactKey == nil || !opts.RedactKey(key) {
+		return key
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return fmt.Sprintf("redacted:%08x", h.Sum32())
+}
+
+// value safe to log
+func Value(v []byte) string {
+	if opts.RedactValues {
+		return fmt.Sprintf("<%d bytes>", len(v))
+	}
+	return string(v)
+}
+
+func write(level Level, msg string, kv []interface{}) {
+	if level < opts.Level {
+		return
+	}
+	var line string
+	if opts.JSON {
+		line = jsonLine(level, msg, kv)
+	} else {
+		line = textLine(level, msg, kv)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if opts.JSON {
+		os.Stderr.WriteString(line + "\n")
+		return
+	}
+	log.Println(line)
+}
+
+func textLine(level Level, msg string, kv []interface{}) string {
+	var b strings.Builder
+	b.WriteString(strings.ToUpper(level.String()))
+	b.WriteString(" ")
+	b.WriteString(msg)
+	for i := 0; i < len(kv); i += 2 {
+		b.WriteString(" ")
+		b.WriteString(fmt.Sprint(kv[i]))
+		b.WriteString("=")
+		if i+1 < len(kv) {
+			b.WriteString(fmt.Sprint(kv[i+1]))
+		}
+	}
+	return b.String()
+}
+
+func jsonLine(level Level, msg string, kv []interface{}) string {
+	m := make(map[string]interface{}, len(kv)/2+3)
+	m["time"] = time.Now().Format(time.RFC3339Nano)
+	m["level"] = level.String()
+	m["msg"] = msg
+	for i := 0; i < len(kv); i += 2 {
+		var v interface{}
+		if i+1 < len(kv) {
+			v = kv[i+1]
+		}
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		m[fmt.Sprint(kv[i])] = v
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return `{"level":"error","msg":"failed to encode log line"}`
+	}
+	return string(b)
+}
@@ -0,0 +1,112 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+// capture text log line written while fn run
+func capture(fn func()) []string {
+	var b bytes.Buffer
+	log.SetOutput(&b)
+	log.SetFlags(0)
+	defer func() {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(log.LstdFlags)
+	}()
+	fn()
+	return strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+}
+
+func TestRedact(t *testing.T) {
+	secret := func(key string) bool { return strings.HasPrefix(key, "secret:") }
+	tests := []struct {
+		name string
+		opts Options
+		key  string
+		want string
+		val  string
+	}{
+		{"no redaction", Options{}, "secret:1", "secret:1", "value"},
+		{"key not matching", Options{RedactKey: secret}, "user:1", "user:1", "value"},
+		{"key matching", Options{RedactKey: secret}, "secret:1", "redacted:", "value"},
+		{"value", Options{RedactValues: true}, "k", "k", "<5 bytes>"},
+	}
+	for _, tt := range tests {
+		Init(tt.opts)
+		if got := Key(tt.key); !strings.HasPrefix(got, tt.want) || (tt.want == "redacted:" && strings.Contains(got, tt.key)) {
+			t.Errorf("%s : Key(%q) = %q, want %q", tt.name, tt.key, got, tt.want)
+		}
+		if got := Value([]byte("value")); got != tt.val {
+			t.Errorf("%s : Value = %q, want %q", tt.name, got, tt.val)
+		}
+	}
+
+	//same key always give the same hash so redacted log line can still be correlated
+	Init(Options{RedactKey: secret})
+	if Key("secret:1") != Key("secret:1") || Key("secret:1") == Key("secret:2") {
+		t.Errorf("got %q %q, want stable hash per key", Key("secret:1"), Key("secret:2"))
+	}
+	Init(Options{})
+}
+
+func TestCommandSample(t *testing.T) {
+	Init(Options{Level: DebugLevel, Sample: map[string]int{"get": 3}, RedactKey: func(string) bool { return true }})
+	defer Init(Options{Level: InfoLevel})
+	lines := capture(func() {
+		for i := 0; i < 7; i++ {
+			Command("GET", "k")
+		}
+		Command("SET", "k")
+		Command("SET", "k")
+	})
+	var gets, sets int
+	for _, l := range lines {
+		switch {
+		case strings.HasPrefix(l, "DEBUG GET "):
+			gets++
+		case strings.HasPrefix(l, "DEBUG SET "):
+			sets++
+		}
+		if strings.Contains(l, "key=k") {
+			t.Errorf("got key name in %q, want it redacted", l)
+		}
+	}
+	//first of every 3 GET, SET is not sampled
+	if gets != 3 || sets != 2 {
+		t.Errorf("got %d GET and %d SET logged, want 3 and 2", gets, sets)
+	}
+
+	Init(Options{Level: InfoLevel})
+	if lines := capture(func() { Command("SET", "k") }); lines[0] != "" {
+		t.Errorf("got %q, want command not logged above debug level", lines)
+	}
+}
+
+func TestParseSample(t *testing.T) {
+	got, err := ParseSample([]string{"get 10", "HGET  2"})
+	if err != nil || got["GET"] != 10 || got["HGET"] != 2 {
+		t.Errorf("got %v %v", got, err)
+	}
+	for _, rule := range []string{"GET", "GET x", "GET 0", "GET 1 2"} {
+		if _, err := ParseSample([]string{rule}); err == nil {
+			t.Errorf("rule %q : got no error", rule)
+		}
+	}
+}
+
+func TestJSONLine(t *testing.T) {
+	var m map[string]interface{}
+	line := jsonLine(WarnLevel, "move failed", []interface{}{"key", "k", "err", errors.New("down"), "odd"})
+	if err := json.Unmarshal([]byte(line), &m); err != nil {
+		t.Fatal(err)
+	}
+	if m["level"] != "warn" || m["msg"] != "move failed" || m["key"] != "k" || m["err"] != "down" || m["odd"] != nil {
+		t.Errorf("got %s", line)
+	}
+}
@@ -1,16 +1,26 @@
 package main
 
 import (
-	"log"
+	"errors"
+	"fmt"
+	"net"
 	"os"
 	"time"
 
 	"github.com/eapache/go-resiliency/semaphore"
 	"github.com/google/gops/agent"
 	redis "github.com/tokopedia/go-redis-server"
+	"github.com/tokopedia/redisgrator/api"
+	"github.com/tokopedia/redisgrator/audit"
 	"github.com/tokopedia/redisgrator/config"
 	"github.com/tokopedia/redisgrator/connection"
 	"github.com/tokopedia/redisgrator/handler"
+	"github.com/tokopedia/redisgrator/logger"
+	"github.com/tokopedia/redisgrator/metrics"
+	"github.com/tokopedia/redisgrator/phase"
+	"github.com/tokopedia/redisgrator/rule"
+	"github.com/tokopedia/redisgrator/slowlog"
+	"github.com/tokopedia/redisgrator/trace"
 )
 
 func init() {
@@ -18,21 +28,101 @@ func init() {
 	if !ok {
 		ok = config.ReadConfig("./files/config/")
 		if !ok {
-			log.Fatal("failed to read config")
-			os.Exit(0)
+			logger.Fatal("failed to read config")
 		}
 	}
-	connection.RedisPoolConnection = connection.RedisConn(config.Cfg.RedisHost.Origin, config.Cfg.RedisHost.Destination)
+	if err := initLogger(config.Cfg.Log); err != nil {
+		logger.Fatal("failed to init logger", "err", err)
+	}
+	rw, err := rule.NewRewriter(config.Cfg.Rewrite)
+	if err != nil {
+		logger.Fatal("failed to load rewrite rules", "err", err)
+	}
+	rule.KeyRewriter = rw
+	pol, err := rule.NewPolicies(config.Cfg.Policy)
+	if err != nil {
+		logger.Fatal("failed to load policy rules", "err", err)
+	}
+	rule.KeyPolicy = pol
+	if config.Cfg.Audit.File != "" {
+		err = audit.Open(config.Cfg.Audit.File, config.Cfg.Audit.MaxSizeMB*1024*1024, config.Cfg.Audit.MaxBackups)
+		if err != nil {
+			logger.Fatal("failed to open audit log", "err", err)
+		}
+	}
+	slowlog.Init(time.Duration(config.Cfg.General.SlowlogSlowerThan)*time.Microsecond, config.Cfg.General.SlowlogMaxLen)
+	if err = initTrace(config.Cfg.Trace); err != nil {
+		logger.Fatal("failed to init tracing", "err", err)
+	}
+	if err = phase.Load(config.Cfg.General.PhaseFile, config.Cfg.General.Phase); err != nil {
+		logger.Fatal("failed to load migration phase", "err", err)
+	}
+	connection.RedisPoolConnection = connection.RedisConn(config.Cfg.RedisHost.Origin, config.Cfg.RedisHost.Destination,
+		poolOptions(config.Cfg.Pool["origin"]), poolOptions(config.Cfg.Pool["destination"]), config.Cfg.General.PipelineBatch)
+}
+
+// pool options of an upstream from config, unset size, idle timeout and breaker keep the default
+func poolOptions(cfg *config.PoolCfg) connection.Options {
+	opt := connection.DefaultOptions
+	if cfg == nil {
+		return opt
+	}
+	opt.MaxActive = cfg.MaxActive
+	opt.Wait = cfg.Wait
+	if cfg.MaxIdle > 0 {
+		opt.MaxIdle = cfg.MaxIdle
+	}
+	if cfg.IdleTimeoutSec > 0 {
+		opt.IdleTimeout = time.Duration(cfg.IdleTimeoutSec) * time.Second
+	}
+	opt.ConnectTimeout = time.Duration(cfg.ConnectTimeoutMs) * time.Millisecond
+	opt.ReadTimeout = time.Duration(cfg.ReadTimeoutMs) * time.Millisecond
+	opt.WriteTimeout = time.Duration(cfg.WriteTimeoutMs) * time.Millisecond
+	opt.LeakTimeout = time.Duration(cfg.LeakTimeoutSec) * time.Second
+	opt.TestOnBorrowAfter = time.Duration(cfg.TestOnBorrowAfterSec) * time.Second
+	if cfg.BreakerErrors > 0 {
+		opt.BreakerErrors = cfg.BreakerErrors
+	}
+	if cfg.BreakerSuccesses > 0 {
+		opt.BreakerSuccesses = cfg.BreakerSuccesses
+	}
+	if cfg.BreakerTimeoutSec > 0 {
+		opt.BreakerTimeout = time.Duration(cfg.BreakerTimeoutSec) * time.Second
+	}
+	return opt
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "verify":
+			os.Exit(verifyCommand(os.Args[2:]))
+		case "repair":
+			os.Exit(repairCommand(os.Args[2:]))
+		}
+	}
 	//gops for monitoring
 	if err := agent.Listen(nil); err != nil {
-		log.Fatal(err)
+		logger.Fatal("failed to start gops agent", "err", err)
+	}
+	//lazy move and mirrored write run in background worker
+	if err := handler.StartWorkers(config.Cfg.Worker); err != nil {
+		logger.Fatal("failed to start background workers", "err", err)
+	}
+	//failed secondary write retried in background
+	if err := handler.StartJournal(config.Cfg.Journal); err != nil {
+		logger.Fatal("failed to open write journal", "err", err)
+	}
+	start := time.Now()
+	//http admin and status api
+	if config.Cfg.General.HTTPPort > 0 {
+		go func() {
+			logger.Fatal("http api stopped", "err", api.ListenAndServe(config.Cfg.General.HTTPHost, config.Cfg.General.HTTPPort, start))
+		}()
 	}
 	//define redis server handler
 	handler := &handler.RedisHandler{
-		Start: time.Now(),
+		Start: start,
 		Sema:  semaphore.New(config.Cfg.General.MaxSema, time.Duration(config.Cfg.General.TimeoutSema)*time.Second),
 	}
 	//define default conf
@@ -41,9 +131,69 @@ func main() {
 	server, err := redis.NewServer(conf)
 
 	if err != nil {
-		log.Println("problem starting redis masquerader server.", err)
-	} else {
-		log.Printf("starting fake redis server at port :%d\n", config.Cfg.General.Port)
-		log.Fatal(server.ListenAndServe())
+		logger.Error("problem starting redis masquerader server", "err", err)
+		return
+	}
+	l, err := net.Listen("tcp", fmt.Sprintf("0.0.0.0:%d", config.Cfg.General.Port))
+	if err != nil {
+		logger.Fatal("failed to listen", "err", err)
+	}
+	go metrics.SampleOps(time.Second)
+	logger.Info("starting fake redis server", "port", config.Cfg.General.Port)
+	logger.Fatal("redis server stopped", "err", server.Serve(countListener{l, handler}))
+}
+
+// set up logger from config, key redaction reuse glob and regex matcher of rule
+func initLogger(cfg config.LogCfg) error {
+	opts := logger.Options{Level: logger.InfoLevel, JSON: cfg.Format == "json", RedactValues: cfg.RedactValues}
+	if cfg.Level != "" {
+		level, err := logger.ParseLevel(cfg.Level)
+		if err != nil {
+			return err
+		}
+		opts.Level = level
+	}
+	var matchers []*rule.Matcher
+	for _, pattern := range cfg.RedactKey {
+		m, err := rule.NewMatcher(pattern)
+		if err != nil {
+			return err
+		}
+		matchers = append(matchers, m)
+	}
+	if len(matchers) > 0 {
+		opts.RedactKey = func(key string) bool {
+			for _, m := range matchers {
+				if m.Match(key) {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	sample, err := logger.ParseSample(cfg.Sample)
+	if err != nil {
+		return err
+	}
+	opts.Sample = sample
+	logger.Init(opts)
+	return nil
+}
+
+func initTrace(cfg config.TraceCfg) error {
+	switch cfg.Exporter {
+	case "", "none":
+		return nil
+	case "stdout":
+		trace.Init(trace.NewWriterExporter(os.Stdout), cfg.SampleRatio)
+	case "file":
+		e, err := trace.NewFileExporter(cfg.File)
+		if err != nil {
+			return err
+		}
+		trace.Init(e, cfg.SampleRatio)
+	default:
+		return errors.New("unknown trace exporter " + cfg.Exporter)
 	}
+	return nil
 }
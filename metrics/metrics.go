@@ -0,0 +1,394 @@
+package metrics
+
+import (
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// default latency buckets in seconds
+var LatencyBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+var (
+	// request per command name
+	Commands = NewCounterVec("redisgrator_commands_total", "Commands processed by the proxy.", "command")
+	// latency of proxied command including upstream call and synchronous move
+	CommandLatency = NewHistogramVec("redisgrator_command_duration_seconds", "Latency of proxied commands.", LatencyBuckets, "command")
+	// latency of each upstream call
+	UpstreamLatency = NewHistogramVec("redisgrator_upstream_duration_seconds", "Latency of calls to origin and destination.", LatencyBuckets, "command", "side")
+	// upstream call returning error
+	UpstreamErrors = NewCounterVec("redisgrator_upstream_errors_total", "Failed calls to origin and destination.", "side")
+	// read answered from origin or destination
+	Hits = NewCounterVec("redisgrator_hits_total", "Reads served per side.", "side")
+	// keys moved per redis type
+	Moved = NewCounterVec("redisgrator_keys_moved_total", "Keys moved between origin and destination.", "type")
+	// big key moved chunk by chunk per redis type
+	ChunkedMoves = NewCounterVec("redisgrator_chunked_moves_total", "Big keys moved in chunks.", "type")
+	// failed move per redis type
+	MoveFailures = NewCounterVec("redisgrator_move_failures_total", "Failed key moves.", "type")
+	// time waiting for semaphore ticket
+	SemaWait = NewHistogramVec("redisgrator_semaphore_wait_seconds", "Time spent waiting for a semaphore ticket.", LatencyBuckets)
+	// request rejected because no semaphore ticket before timeout
+	SemaTimeouts = NewCounterVec("redisgrator_semaphore_timeouts_total", "Requests rejected after semaphore timeout.")
+	// connection of upstream pool
+	PoolActive = NewGaugeFuncVec("redisgrator_pool_active_connections", "Active connections in upstream pool.", "side")
+	PoolIdle   = NewGaugeFuncVec("redisgrator_pool_idle_connections", "Idle connections in upstream pool.", "side")
+	// upstream breaker, 1 while open and calls to that side fail fast
+	UpstreamDown       = NewGaugeFuncVec("redisgrator_upstream_down", "Upstream whose circuit breaker is open.", "side")
+	BreakerTransitions = NewCounterVec("redisgrator_breaker_transitions_total", "Circuit breaker state changes.", "side", "state")
+	// connection acquired from upstream pool and not given back yet
+	PoolBorrowed      = NewGaugeFuncVec("redisgrator_pool_borrowed_connections", "Connections acquired from upstream pool and not closed.", "side")
+	LeakedConnections = NewCounterVec("redisgrator_pool_leaked_connections_total", "Connections held longer than leak timeout.", "side")
+	// per key lock acquired while held by another request or move
+	LockContentions = NewCounterVec("redisgrator_key_lock_contentions_total", "Key lock acquisitions that had to wait.")
+	LockWait        = NewHistogramVec("redisgrator_key_lock_wait_seconds", "Time spent waiting for a contended key lock.", LatencyBuckets)
+	// background move skipped because the same key is already being moved
+	MovesDeduplicated = NewCounterVec("redisgrator_moves_deduplicated_total", "Background moves skipped as already in flight.")
+	// read compared between origin and destination while origin is authoritative
+	ShadowReads      = NewCounterVec("redisgrator_shadow_reads_total", "Reads compared between origin and destination.", "command")
+	ShadowMismatches = NewCounterVec("redisgrator_shadow_mismatches_total", "Compared reads where destination differ from origin.", "command")
+	// run of GET in a client pipeline answered with one pipelined round trip per upstream
+	PipelineBatches = NewCounterVec("redisgrator_pipeline_batches_total", "Batches of pipelined GET answered together.")
+	PipelinedGets   = NewCounterVec("redisgrator_pipelined_gets_total", "GET answered as part of a pipelined batch.")
+	// background move and mirrored write run by worker pool, per task kind
+	TasksQueued       = NewCounterVec("redisgrator_worker_tasks_queued_total", "Tasks queued to background workers.", "kind")
+	TasksDropped      = NewCounterVec("redisgrator_worker_tasks_dropped_total", "Tasks dropped because worker queue was full.", "kind")
+	TasksSpilled      = NewCounterVec("redisgrator_worker_tasks_spilled_total", "Tasks spilled to disk because worker queue was full.", "kind")
+	TasksDeduplicated = NewCounterVec("redisgrator_worker_tasks_deduplicated_total", "Tasks skipped as the same one is already queued.", "kind")
+	WorkerQueueDepth  = NewGaugeFuncVec("redisgrator_worker_queue_depth", "Tasks waiting for background workers.", "state")
+	// write that failed on secondary side, kept in journal until replayed
+	JournalRecorded = NewCounterVec("redisgrator_journal_recorded_total", "Failed secondary writes recorded in journal.", "side")
+	JournalReplayed = NewCounterVec("redisgrator_journal_replayed_total", "Journal entries retried per outcome.", "outcome")
+	JournalPending  = NewGauge("redisgrator_journal_pending", "Journal entries waiting for retry.")
+	// client connection to the proxy listener
+	ConnectedClients    = NewGauge("redisgrator_connected_clients", "Client connections currently open.")
+	ConnectionsReceived = NewCounterVec("redisgrator_connections_received_total", "Client connections accepted.")
+)
+
+// commands per second measured over last sampling interval
+var opsPerSec int64
+
+// sample command rate every interval, meant to run in its own goroutine
+func SampleOps(interval time.Duration) {
+	last := Commands.Total()
+	for range time.Tick(interval) {
+		total := Commands.Total()
+		atomic.StoreInt64(&opsPerSec, int64(float64(total-last)/interval.Seconds()))
+		last = total
+	}
+}
+
+func OpsPerSec() int64 {
+	return atomic.LoadInt64(&opsPerSec)
+}
+
+type collector interface {
+	write(w io.Writer)
+}
+
+var (
+	registryMu sync.Mutex
+	registry   []collector
+)
+
+func register(c collector) {
+	registryMu.Lock()
+	registry = append(registry, c)
+	registryMu.Unlock()
+}
+
+// write all metrics in prometheus text exposition format
+func WritePrometheus(w io.Writer) {
+	registryMu.Lock()
+	cs := append([]collector(nil), registry...)
+	registryMu.Unlock()
+	for _, c := range cs {
+		c.write(w)
+	}
+}
+
+type desc struct {
+	name   string
+	help   string
+	labels []string
+}
+
+func (d desc) header(w io.Writer, typ string) {
+	io.WriteString(w, "# HELP "+d.name+" "+d.help+"\n")
+	io.WriteString(w, "# TYPE "+d.name+" "+typ+"\n")
+}
+
+// render label pairs, extra is appended as is (used for le of histogram)
+func (d desc) labelString(values []string, extra string) string {
+	var pairs []string
+	for i, l := range d.labels {
+		v := ""
+		if i < len(values) {
+			v = values[i]
+		}
+		pairs = append(pairs, l+`="`+escape(v)+`"`)
+	}
+	if extra != "" {
+		pairs = append(pairs, extra)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func escape(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+// series key, label value joined by comma
+func seriesKey(values []string) string {
+	return strings.Join(values, ",")
+}
+
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// set of counters identified by label values
+type CounterVec struct {
+	desc
+	mu     sync.RWMutex
+	m      map[string]*int64
+	values map[string][]string
+}
+
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{
+		desc:   desc{name: name, help: help, labels: labels},
+		m:      make(map[string]*int64),
+		values: make(map[string][]string),
+	}
+	register(c)
+	return c
+}
+
+func (c *CounterVec) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+func (c *CounterVec) Add(n int64, values ...string) {
+	key := seriesKey(values)
+	c.mu.RLock()
+	v, ok := c.m[key]
+	c.mu.RUnlock()
+	if !ok {
+		c.mu.Lock()
+		v, ok = c.m[key]
+		if !ok {
+			v = new(int64)
+			c.m[key] = v
+			c.values[key] = values
+		}
+		c.mu.Unlock()
+	}
+	atomic.AddInt64(v, n)
+}
+
+// copy of all counter value keyed by label values joined with comma
+func (c *CounterVec) Snapshot() map[string]int64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	res := make(map[string]int64, len(c.m))
+	for k, v := range c.m {
+		res[k] = atomic.LoadInt64(v)
+	}
+	return res
+}
+
+// sum of all counter
+func (c *CounterVec) Total() int64 {
+	var total int64
+	for _, v := range c.Snapshot() {
+		total += v
+	}
+	return total
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.header(w, "counter")
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, k := range sortedKeys(c.values) {
+		io.WriteString(w, c.name+c.labelString(c.values[k], "")+" "+formatInt(atomic.LoadInt64(c.m[k]))+"\n")
+	}
+}
+
+type histogram struct {
+	mu     sync.Mutex
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// set of histograms identified by label values
+type HistogramVec struct {
+	desc
+	buckets []float64
+	mu      sync.RWMutex
+	m       map[string]*histogram
+	values  map[string][]string
+}
+
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{
+		desc:    desc{name: name, help: help, labels: labels},
+		buckets: buckets,
+		m:       make(map[string]*histogram),
+		values:  make(map[string][]string),
+	}
+	register(h)
+	return h
+}
+
+func (h *HistogramVec) Observe(v float64, values ...string) {
+	key := seriesKey(values)
+	h.mu.RLock()
+	s, ok := h.m[key]
+	h.mu.RUnlock()
+	if !ok {
+		h.mu.Lock()
+		s, ok = h.m[key]
+		if !ok {
+			s = &histogram{counts: make([]uint64, len(h.buckets))}
+			h.m[key] = s
+			h.values[key] = values
+		}
+		h.mu.Unlock()
+	}
+	s.mu.Lock()
+	for i, b := range h.buckets {
+		if v <= b {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += v
+	s.mu.Unlock()
+}
+
+// number of observation per label values joined with comma
+func (h *HistogramVec) Counts() map[string]uint64 {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	res := make(map[string]uint64, len(h.m))
+	for k, s := range h.m {
+		s.mu.Lock()
+		res[k] = s.count
+		s.mu.Unlock()
+	}
+	return res
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.header(w, "histogram")
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, k := range sortedKeys(h.values) {
+		values := h.values[k]
+		s := h.m[k]
+		s.mu.Lock()
+		for i, b := range h.buckets {
+			io.WriteString(w, h.name+"_bucket"+h.labelString(values, `le="`+formatFloat(b)+`"`)+" "+formatUint(s.counts[i])+"\n")
+		}
+		io.WriteString(w, h.name+"_bucket"+h.labelString(values, `le="+Inf"`)+" "+formatUint(s.count)+"\n")
+		io.WriteString(w, h.name+"_sum"+h.labelString(values, "")+" "+formatFloat(s.sum)+"\n")
+		io.WriteString(w, h.name+"_count"+h.labelString(values, "")+" "+formatUint(s.count)+"\n")
+		s.mu.Unlock()
+	}
+}
+
+// single value that can go up and down
+type Gauge struct {
+	desc
+	v int64
+}
+
+func NewGauge(name, help string) *Gauge {
+	g := &Gauge{desc: desc{name: name, help: help}}
+	register(g)
+	return g
+}
+
+func (g *Gauge) Inc() {
+	atomic.AddInt64(&g.v, 1)
+}
+
+func (g *Gauge) Dec() {
+	atomic.AddInt64(&g.v, -1)
+}
+
+func (g *Gauge) Value() int64 {
+	return atomic.LoadInt64(&g.v)
+}
+
+func (g *Gauge) write(w io.Writer) {
+	g.header(w, "gauge")
+	io.WriteString(w, g.name+" "+formatInt(g.Value())+"\n")
+}
+
+// gauges evaluated when metrics collected, one function per label value
+type GaugeFuncVec struct {
+	desc
+	mu  sync.RWMutex
+	fns map[string]func() float64
+}
+
+func NewGaugeFuncVec(name, help, label string) *GaugeFuncVec {
+	g := &GaugeFuncVec{
+		desc: desc{name: name, help: help, labels: []string{label}},
+		fns:  make(map[string]func() float64),
+	}
+	register(g)
+	return g
+}
+
+func (g *GaugeFuncVec) Set(value string, fn func() float64) {
+	g.mu.Lock()
+	g.fns[value] = fn
+	g.mu.Unlock()
+}
+
+func (g *GaugeFuncVec) write(w io.Writer) {
+	g.header(w, "gauge")
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	values := make([]string, 0, len(g.fns))
+	for v := range g.fns {
+		values = append(values, v)
+	}
+	sort.Strings(values)
+	for _, v := range values {
+		io.WriteString(w, g.name+g.labelString([]string{v}, "")+" "+formatFloat(g.fns[v]())+"\n")
+	}
+}
+
+func formatInt(v int64) string {
+	return strconv.FormatInt(v, 10)
+}
+
+func formatUint(v uint64) string {
+	return strconv.FormatUint(v, 10)
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
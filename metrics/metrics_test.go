@@ -0,0 +1,106 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func text(c collector) string {
+	var b bytes.Buffer
+	c.write(&b)
+	return b.String()
+}
+
+func TestCounterText(t *testing.T) {
+	c := NewCounterVec("test_requests_total", "Requests per command.", "command", "side")
+	c.Inc("SET", "origin")
+	c.Add(3, "GET", "destination")
+	c.Inc("GET", "destination")
+	want := `# HELP test_requests_total Requests per command.
+# TYPE test_requests_total counter
+test_requests_total{command="GET",side="destination"} 4
+test_requests_total{command="SET",side="origin"} 1
+`
+	if got := text(c); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	if c.Total() != 5 || c.Snapshot()["GET,destination"] != 4 {
+		t.Errorf("got total %d snapshot %v", c.Total(), c.Snapshot())
+	}
+
+	//no label, no braces
+	c = NewCounterVec("test_plain_total", "Plain counter.")
+	c.Inc()
+	if got := text(c); !strings.HasSuffix(got, "\ntest_plain_total 1\n") {
+		t.Errorf("got\n%s", got)
+	}
+}
+
+func TestLabelEscape(t *testing.T) {
+	c := NewCounterVec("test_escape_total", "Escaped label.", "key")
+	c.Inc(`a"b\c` + "\nd")
+	want := `test_escape_total{key="a\"b\\c\nd"} 1`
+	if got := text(c); !strings.Contains(got, want+"\n") {
+		t.Errorf("got\n%s\nwant line %s", got, want)
+	}
+}
+
+func TestHistogramText(t *testing.T) {
+	h := NewHistogramVec("test_duration_seconds", "Latency.", []float64{.1, 1}, "command")
+	h.Observe(.05, "GET")
+	h.Observe(.5, "GET")
+	h.Observe(2, "GET")
+	want := `# HELP test_duration_seconds Latency.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{command="GET",le="0.1"} 1
+test_duration_seconds_bucket{command="GET",le="1"} 2
+test_duration_seconds_bucket{command="GET",le="+Inf"} 3
+test_duration_seconds_sum{command="GET"} 2.55
+test_duration_seconds_count{command="GET"} 3
+`
+	if got := text(h); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	if h.Counts()["GET"] != 3 {
+		t.Errorf("got counts %v", h.Counts())
+	}
+}
+
+func TestGaugeText(t *testing.T) {
+	g := NewGauge("test_clients", "Clients.")
+	g.Inc()
+	g.Inc()
+	g.Dec()
+	if got, want := text(g), "# HELP test_clients Clients.\n# TYPE test_clients gauge\ntest_clients 1\n"; got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	f := NewGaugeFuncVec("test_pool", "Pool.", "side")
+	f.Set("origin", func() float64 { return 2 })
+	f.Set("destination", func() float64 { return 0.5 })
+	want := "# HELP test_pool Pool.\n# TYPE test_pool gauge\ntest_pool{side=\"destination\"} 0.5\ntest_pool{side=\"origin\"} 2\n"
+	if got := text(f); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestWritePrometheus(t *testing.T) {
+	var b bytes.Buffer
+	WritePrometheus(&b)
+	out := b.String()
+	//every registered metric has its own HELP and TYPE once
+	for _, name := range []string{"redisgrator_commands_total", "redisgrator_upstream_duration_seconds", "redisgrator_journal_pending"} {
+		if strings.Count(out, "# HELP "+name+" ") != 1 || strings.Count(out, "# TYPE "+name+" ") != 1 {
+			t.Errorf("got HELP/TYPE of %s %d times", name, strings.Count(out, "# TYPE "+name+" "))
+		}
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(out, "\n"), "\n") {
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		if fields := strings.Fields(line); len(fields) != 2 {
+			t.Errorf("got malformed sample line %q", line)
+		}
+	}
+}
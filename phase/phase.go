@@ -0,0 +1,156 @@
+package phase
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/tokopedia/redisgrator/logger"
+)
+
+type Phase int32
+
+const (
+	// origin authoritative, keys copied to destination in background
+	Shadow Phase = iota
+	// origin authoritative, every write applied to both side synchronously
+	DualWrite
+	// destination authoritative, fallback to origin and move missing keys
+	DestPrimary
+	// destination only, origin is not touched anymore
+	DestOnly
+	// reverse migration, origin authoritative again and keys moved back from destination
+	Rollback
+)
+
+var names = []string{"shadow", "dual-write", "dest-primary", "dest-only", "rollback"}
+
+// phases walked by Next and Prev, rollback must be set explicitly
+const lastStep = DestOnly
+
+var (
+	current = int32(DestPrimary)
+	// file to persist phase, empty mean phase is not persisted
+	stateFile string
+	mu        sync.Mutex
+)
+
+func (p Phase) String() string {
+	if p < 0 || int(p) >= len(names) {
+		return "unknown"
+	}
+	return names[p]
+}
+
+// origin still hold the truth, destination is only a copy
+func (p Phase) OriginPrimary() bool {
+	return p == Shadow || p == DualWrite
+}
+
+// key in source side may be deleted once moved to target side
+func (p Phase) DeletesSource() bool {
+	return p == DestPrimary || p == DestOnly || p == Rollback
+}
+
+func Parse(name string) (Phase, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for i, n := range names {
+		if n == name {
+			return Phase(i), nil
+		}
+	}
+	return 0, errors.New("unknown phase " + name + ", valid : " + strings.Join(names, ", "))
+}
+
+// load phase from state file, fallback to def when file does not exist yet
+func Load(path, def string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	stateFile = path
+
+	name := def
+	if path != "" {
+		b, err := ioutil.ReadFile(path)
+		if err == nil {
+			name = string(b)
+			logger.Info("resume phase from state file", "path", path)
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if name == "" {
+		name = DestPrimary.String()
+	}
+	p, err := Parse(name)
+	if err != nil {
+		return err
+	}
+	atomic.StoreInt32(&current, int32(p))
+	logger.Info("migration phase", "phase", p)
+	return nil
+}
+
+func Current() Phase {
+	return Phase(atomic.LoadInt32(&current))
+}
+
+// switch to phase p, state file is written first so restart resume in p
+func Set(p Phase) error {
+	if p < 0 || int(p) >= len(names) {
+		return errors.New("unknown phase")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	return set(p)
+}
+
+// advance to next phase
+func Next() (Phase, error) {
+	return step(1)
+}
+
+// roll back to previous phase
+func Prev() (Phase, error) {
+	return step(-1)
+}
+
+func step(delta Phase) (Phase, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	cur := Current()
+	if cur == Rollback {
+		return cur, errors.New("leaving rollback must set phase explicitly")
+	}
+	p := cur + delta
+	if p < 0 || p > lastStep {
+		return cur, errors.New("no phase beyond " + cur.String())
+	}
+	return p, set(p)
+}
+
+func set(p Phase) error {
+	if err := persist(p); err != nil {
+		return err
+	}
+	old := Phase(atomic.SwapInt32(&current, int32(p)))
+	logger.Info("migration phase changed", "from", old, "to", p)
+	return nil
+}
+
+func persist(p Phase) error {
+	if stateFile == "" {
+		return nil
+	}
+	tmp := stateFile + ".tmp"
+	if err := os.MkdirAll(filepath.Dir(stateFile), 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(tmp, []byte(p.String()), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, stateFile)
+}
@@ -0,0 +1,136 @@
+package phase
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func load(t *testing.T, path, def string) {
+	if err := Load(path, def); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { Load("", "") })
+}
+
+func TestPersist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "phase")
+	load(t, path, "shadow")
+	if Current() != Shadow {
+		t.Fatalf("got %s, want default phase", Current())
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("got %v, want no state file before phase change", err)
+	}
+
+	if err := Set(DualWrite); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadFile(path); err != nil || string(b) != "dual-write" {
+		t.Errorf("got %q %v in state file", b, err)
+	}
+	//written through a temp file renamed over the state file
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("got %v, want temp file renamed", err)
+	}
+
+	//restart resume persisted phase instead of default
+	Load("", "")
+	load(t, path, "shadow")
+	if Current() != DualWrite {
+		t.Errorf("got %s after reload, want dual-write", Current())
+	}
+
+	//hand edited file is accepted
+	ioutil.WriteFile(path, []byte(" Dest-Only\n"), 0644)
+	load(t, path, "shadow")
+	if Current() != DestOnly {
+		t.Errorf("got %s, want dest-only", Current())
+	}
+}
+
+func TestLoadError(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "phase")
+	ioutil.WriteFile(path, []byte("unknown"), 0644)
+	if err := Load(path, "shadow"); err == nil {
+		t.Error("got no error for unknown phase in state file")
+	}
+	if err := Load(dir, "shadow"); err == nil {
+		t.Error("got no error for unreadable state file")
+	}
+	if err := Load("", "bogus"); err == nil {
+		t.Error("got no error for unknown default")
+	}
+	load(t, "", "")
+	if Current() != DestPrimary {
+		t.Errorf("got %s, want dest-primary without state file nor default", Current())
+	}
+}
+
+func TestSetPersistFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "phase")
+	//temp file path is taken by a directory, nothing can be written
+	os.Mkdir(path+".tmp", 0755)
+	load(t, path, "shadow")
+	if err := Set(DestOnly); err == nil {
+		t.Error("got no error when state file can not be written")
+	}
+	if _, err := Next(); err == nil {
+		t.Error("got no error on Next when state file can not be written")
+	}
+	if Current() != Shadow {
+		t.Errorf("got %s, want phase unchanged when not persisted", Current())
+	}
+}
+
+func TestStep(t *testing.T) {
+	load(t, "", "shadow")
+	if p, err := Prev(); err == nil || p != Shadow {
+		t.Errorf("got %s %v, want no phase before shadow", p, err)
+	}
+	for _, want := range []Phase{DualWrite, DestPrimary, DestOnly} {
+		if p, err := Next(); err != nil || p != want || Current() != want {
+			t.Fatalf("got %s %v, want %s", p, err, want)
+		}
+	}
+	//rollback is never reached by stepping
+	if p, err := Next(); err == nil || Current() != DestOnly {
+		t.Errorf("got %s %v, want to stay in dest-only", p, err)
+	}
+	if p, err := Prev(); err != nil || p != DestPrimary {
+		t.Errorf("got %s %v, want dest-primary", p, err)
+	}
+
+	if err := Set(Rollback); err != nil {
+		t.Fatal(err)
+	}
+	for name, step := range map[string]func() (Phase, error){"Next": Next, "Prev": Prev} {
+		if _, err := step(); err == nil || Current() != Rollback {
+			t.Errorf("%s : got %v in %s, want rollback left only explicitly", name, err, Current())
+		}
+	}
+	if err := Set(Shadow); err != nil || Current() != Shadow {
+		t.Errorf("got %s %v, want explicit set to leave rollback", Current(), err)
+	}
+	for _, p := range []Phase{-1, Rollback + 1} {
+		if err := Set(p); err == nil {
+			t.Errorf("Set(%d) : got no error", p)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	for i, name := range names {
+		if p, err := Parse(name); err != nil || p != Phase(i) || p.String() != name {
+			t.Errorf("Parse(%q) = %s %v", name, p, err)
+		}
+	}
+	if _, err := Parse("primary"); err == nil {
+		t.Error("got no error for unknown phase")
+	}
+	if Phase(42).String() != "unknown" {
+		t.Errorf("got %q", Phase(42).String())
+	}
+}
@@ -0,0 +1,445 @@
+package redistest
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	rds "github.com/garyburd/redigo/redis"
+)
+
+// element per SCAN page when COUNT is not given, like redis
+const defaultCount = 10
+
+// in memory redis for tests holding string, hash, set and sorted set, enough for the command
+// proxied, moved and verified. value is string, map[string]string for hash, map[string]bool
+// for set and map[string]float64 for sorted set
+type Redis struct {
+	mu   sync.Mutex
+	Data map[string]interface{}
+	// ttl in second of key with expiry
+	TTL map[string]int64
+	// every command run, name and argument separated by space
+	Log []string
+	// command failing with ErrInjected, count of call left to fail, negative fail forever
+	fail map[string]int
+}
+
+var ErrInjected = rds.Error("ERR injected failure")
+
+func New() *Redis {
+	return &Redis{Data: make(map[string]interface{}), TTL: make(map[string]int64), fail: make(map[string]int)}
+}
+
+// make the next times call of cmd fail, times negative make every call fail
+func (r *Redis) FailNext(cmd string, times int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.fail[strings.ToUpper(cmd)] = times
+}
+
+// command logged so far, reset the log
+func (r *Redis) Calls() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	res := r.Log
+	r.Log = nil
+	return res
+}
+
+func (r *Redis) Get(key string) interface{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.Data[key]
+}
+
+func (r *Redis) Set(key string, v interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Data[key] = v
+}
+
+// log cmd and return injected failure if any, caller hold r.mu
+func (r *Redis) record(cmd string, args ...string) error {
+	r.Log = append(r.Log, strings.Join(append([]string{cmd}, args...), " "))
+	return r.failure(cmd)
+}
+
+// injected failure of cmd if any, caller hold r.mu
+func (r *Redis) failure(cmd string) error {
+	n, ok := r.fail[cmd]
+	if !ok || n == 0 {
+		return nil
+	}
+	if n > 0 {
+		r.fail[cmd] = n - 1
+	}
+	return ErrInjected
+}
+
+func (r *Redis) Exec(cmd string, args []interface{}) (interface{}, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cmd = strings.ToUpper(cmd)
+	s := make([]string, len(args))
+	for i, a := range args {
+		switch v := a.(type) {
+		case []byte:
+			s[i] = string(v)
+		default:
+			s[i] = fmt.Sprint(v)
+		}
+	}
+	if err := r.record(cmd, s...); err != nil {
+		return nil, err
+	}
+	key := ""
+	if len(s) > 0 {
+		key = s[0]
+	}
+	h, _ := r.Data[key].(map[string]string)
+	set, _ := r.Data[key].(map[string]bool)
+	zset, _ := r.Data[key].(map[string]float64)
+	switch cmd {
+	case "PING":
+		return "PONG", nil
+	case "DBSIZE":
+		return int64(len(r.Data)), nil
+	case "GET":
+		if v, ok := r.Data[key].(string); ok {
+			return []byte(v), nil
+		}
+		return nil, nil
+	case "SET":
+		r.Data[key] = s[1]
+		delete(r.TTL, key)
+		return "OK", nil
+	case "SETEX":
+		r.Data[key] = s[2]
+		r.TTL[key], _ = strconv.ParseInt(s[1], 10, 64)
+		return "OK", nil
+	case "DEL", "UNLINK":
+		n := int64(0)
+		for _, k := range s {
+			if _, ok := r.Data[k]; ok {
+				delete(r.Data, k)
+				delete(r.TTL, k)
+				n++
+			}
+		}
+		return n, nil
+	case "EXISTS":
+		if _, ok := r.Data[key]; ok {
+			return int64(1), nil
+		}
+		return int64(0), nil
+	case "EXPIRE", "PEXPIRE":
+		if _, ok := r.Data[key]; !ok {
+			return int64(0), nil
+		}
+		ttl, _ := strconv.ParseInt(s[1], 10, 64)
+		if cmd == "PEXPIRE" {
+			ttl /= 1000
+		}
+		r.TTL[key] = ttl
+		return int64(1), nil
+	case "TTL", "PTTL":
+		if _, ok := r.Data[key]; !ok {
+			return int64(-2), nil
+		}
+		ttl, ok := r.TTL[key]
+		switch {
+		case !ok:
+			return int64(-1), nil
+		case cmd == "PTTL":
+			return ttl * 1000, nil
+		}
+		return ttl, nil
+	case "TYPE":
+		switch r.Data[key].(type) {
+		case string:
+			return "string", nil
+		case map[string]string:
+			return "hash", nil
+		case map[string]bool:
+			return "set", nil
+		case map[string]float64:
+			return "zset", nil
+		}
+		return "none", nil
+	case "SCAN":
+		keys := make(map[string]bool, len(r.Data))
+		for k := range r.Data {
+			keys[k] = true
+		}
+		return scan(sortedKeys(keys), s, 0, func(k string) []interface{} { return []interface{}{[]byte(k)} })
+	case "HSET", "HMSET":
+		if h == nil {
+			h = make(map[string]string)
+			r.Data[key] = h
+		}
+		n := int64(0)
+		for i := 1; i+1 < len(s); i += 2 {
+			if _, ok := h[s[i]]; !ok {
+				n++
+			}
+			h[s[i]] = s[i+1]
+		}
+		if cmd == "HMSET" {
+			return "OK", nil
+		}
+		return n, nil
+	case "HGET":
+		if v, ok := h[s[1]]; ok {
+			return []byte(v), nil
+		}
+		return nil, nil
+	case "HEXISTS":
+		if _, ok := h[s[1]]; ok {
+			return int64(1), nil
+		}
+		return int64(0), nil
+	case "HLEN":
+		return int64(len(h)), nil
+	case "HGETALL":
+		reply := []interface{}{}
+		for _, f := range sortedKeys(h) {
+			reply = append(reply, []byte(f), []byte(h[f]))
+		}
+		return reply, nil
+	case "HSCAN":
+		return scan(sortedKeys(h), s, 1, func(f string) []interface{} { return []interface{}{[]byte(f), []byte(h[f])} })
+	case "SADD", "SREM":
+		if set == nil {
+			set = make(map[string]bool)
+			r.Data[key] = set
+		}
+		n := int64(0)
+		for _, m := range s[1:] {
+			if set[m] == (cmd == "SREM") {
+				n++
+			}
+			if cmd == "SREM" {
+				delete(set, m)
+			} else {
+				set[m] = true
+			}
+		}
+		if len(set) == 0 {
+			delete(r.Data, key)
+		}
+		return n, nil
+	case "SISMEMBER":
+		if set[s[1]] {
+			return int64(1), nil
+		}
+		return int64(0), nil
+	case "SCARD":
+		return int64(len(set)), nil
+	case "SMEMBERS":
+		reply := []interface{}{}
+		for _, m := range sortedKeys(set) {
+			reply = append(reply, []byte(m))
+		}
+		return reply, nil
+	case "SSCAN":
+		return scan(sortedKeys(set), s, 1, func(m string) []interface{} { return []interface{}{[]byte(m)} })
+	case "ZADD":
+		if zset == nil {
+			zset = make(map[string]float64)
+			r.Data[key] = zset
+		}
+		n := int64(0)
+		for i := 1; i+1 < len(s); i += 2 {
+			score, err := strconv.ParseFloat(s[i], 64)
+			if err != nil {
+				return nil, rds.Error("ERR value is not a valid float")
+			}
+			if _, ok := zset[s[i+1]]; !ok {
+				n++
+			}
+			zset[s[i+1]] = score
+		}
+		return n, nil
+	case "ZCARD":
+		return int64(len(zset)), nil
+	case "ZRANGE":
+		//whole set with score only, ordered by score then member
+		members := sortedKeys(zset)
+		sort.SliceStable(members, func(i, j int) bool { return zset[members[i]] < zset[members[j]] })
+		reply := []interface{}{}
+		for _, m := range members {
+			reply = append(reply, []byte(m), []byte(formatScore(zset[m])))
+		}
+		return reply, nil
+	case "ZSCAN":
+		return scan(sortedKeys(zset), s, 1, func(m string) []interface{} {
+			return []interface{}{[]byte(m), []byte(formatScore(zset[m]))}
+		})
+	}
+	return nil, rds.Error("ERR unknown command " + cmd)
+}
+
+func formatScore(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// page of SCAN family reply, cursor is index in sorted elements, cursor and option start at args[at]
+func scan(elems []string, args []string, at int, reply func(string) []interface{}) (interface{}, error) {
+	cursor, err := strconv.Atoi(args[at])
+	if err != nil {
+		return nil, rds.Error("ERR invalid cursor")
+	}
+	count, match := defaultCount, ""
+	for i := at + 1; i+1 < len(args); i += 2 {
+		switch strings.ToUpper(args[i]) {
+		case "COUNT":
+			count, _ = strconv.Atoi(args[i+1])
+		case "MATCH":
+			match = args[i+1]
+		}
+	}
+	page := []interface{}{}
+	end := cursor + count
+	if end >= len(elems) {
+		end = len(elems)
+	}
+	for _, e := range elems[cursor:end] {
+		if ok, _ := path.Match(match, e); match == "" || ok {
+			page = append(page, reply(e)...)
+		}
+	}
+	next := end
+	if end == len(elems) {
+		next = 0
+	}
+	return []interface{}{[]byte(strconv.Itoa(next)), page}, nil
+}
+
+func sortedKeys(m interface{}) []string {
+	keys := []string{}
+	switch v := m.(type) {
+	case map[string]string:
+		for k := range v {
+			keys = append(keys, k)
+		}
+	case map[string]bool:
+		for k := range v {
+			keys = append(keys, k)
+		}
+	case map[string]float64:
+		for k := range v {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+type call struct {
+	cmd  string
+	args []interface{}
+}
+
+// connection to Redis, pipelined command and MULTI/EXEC are run in order.
+// failing EXEC discard the transaction like EXECABORT
+type Conn struct {
+	r       *Redis
+	pending []call
+	multi   []call
+	inMulti bool
+}
+
+func NewConn(r *Redis) *Conn {
+	return &Conn{r: r}
+}
+
+func (c *Conn) Close() error { return nil }
+func (c *Conn) Err() error   { return nil }
+func (c *Conn) Flush() error { return nil }
+
+func (c *Conn) Send(cmd string, args ...interface{}) error {
+	c.pending = append(c.pending, call{cmd, args})
+	return nil
+}
+
+func (c *Conn) Receive() (interface{}, error) {
+	if len(c.pending) == 0 {
+		return nil, errors.New("no pending reply")
+	}
+	next := c.pending[0]
+	c.pending = c.pending[1:]
+	return c.run(next)
+}
+
+func (c *Conn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	//like redigo, Do return the last reply and the first error of pipelined command
+	var reply interface{}
+	var err error
+	for len(c.pending) > 0 {
+		if _, e := c.Receive(); e != nil && err == nil {
+			err = e
+		}
+	}
+	if cmd != "" {
+		var e error
+		if reply, e = c.run(call{cmd, args}); e != nil && err == nil {
+			err = e
+		}
+	}
+	return reply, err
+}
+
+func (c *Conn) run(cl call) (interface{}, error) {
+	cmd := strings.ToUpper(cl.cmd)
+	switch {
+	case cmd == "MULTI":
+		c.r.mu.Lock()
+		defer c.r.mu.Unlock()
+		c.inMulti = true
+		return "OK", c.r.record(cmd)
+	case cmd == "EXEC":
+		//queued command is logged when run between MULTI and EXEC, failing EXEC run none of them
+		queued := c.multi
+		c.inMulti, c.multi = false, nil
+		c.r.mu.Lock()
+		err := c.r.failure(cmd)
+		if err != nil {
+			c.r.Log = append(c.r.Log, cmd)
+		}
+		c.r.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		replies := []interface{}{}
+		for _, q := range queued {
+			v, err := c.r.Exec(q.cmd, q.args)
+			if err != nil {
+				v = err
+			}
+			replies = append(replies, v)
+		}
+		c.r.mu.Lock()
+		c.r.Log = append(c.r.Log, cmd)
+		c.r.mu.Unlock()
+		return replies, nil
+	case c.inMulti:
+		c.multi = append(c.multi, cl)
+		return "QUEUED", nil
+	}
+	return c.r.Exec(cl.cmd, cl.args)
+}
+
+// dial function for connection.Pool, dial fail while down is set
+func Dial(r *Redis, down *bool) func() (rds.Conn, error) {
+	return func() (rds.Conn, error) {
+		if down != nil && *down {
+			return nil, errors.New("connection refused")
+		}
+		return NewConn(r), nil
+	}
+}
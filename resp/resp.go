@@ -0,0 +1,117 @@
+package resp
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+const (
+	// same limits as redis server, anything above is refused before allocating
+	MaxArgs    = 1024 * 1024
+	MaxBulkLen = 512 * 1024 * 1024
+	// inline command without newline longer than this is refused
+	MaxInline = 64 * 1024
+)
+
+var (
+	ErrIncomplete = errors.New("incomplete command")
+	ErrArgs       = errors.New("invalid multibulk length")
+	ErrBulkLen    = errors.New("invalid bulk length")
+	ErrInline     = errors.New("too big inline request")
+)
+
+// parse one RESP multi bulk or inline command at start of b,
+// return its arguments and byte length, ErrIncomplete when b end before the command
+func ParseCommand(b []byte) ([]string, int, error) {
+	if len(b) == 0 {
+		return nil, 0, ErrIncomplete
+	}
+	if b[0] != '*' {
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 {
+			if len(b) > MaxInline {
+				return nil, 0, ErrInline
+			}
+			return nil, 0, ErrIncomplete
+		}
+		return strings.Fields(string(b[:i])), i + 1, nil
+	}
+	count, pos, err := parseLine(b, 0, '*')
+	if err != nil {
+		return nil, 0, err
+	}
+	if count < 0 || count > MaxArgs {
+		return nil, 0, ErrArgs
+	}
+	var args []string
+	for i := 0; i < count; i++ {
+		var size int
+		size, pos, err = parseLine(b, pos, '$')
+		if err != nil {
+			return nil, 0, err
+		}
+		if size < 0 || size > MaxBulkLen {
+			return nil, 0, ErrBulkLen
+		}
+		if len(b)-pos < size+2 {
+			return nil, 0, ErrIncomplete
+		}
+		args = append(args, string(b[pos:pos+size]))
+		pos += size + 2
+	}
+	return args, pos, nil
+}
+
+// parse "<prefix><int>\r\n" at pos, return the int and position after the line
+func parseLine(b []byte, pos int, prefix byte) (int, int, error) {
+	if pos >= len(b) {
+		return 0, 0, ErrIncomplete
+	}
+	if b[pos] != prefix {
+		return 0, 0, errors.New("expected '" + string(prefix) + "'")
+	}
+	i := bytes.IndexByte(b[pos:], '\n')
+	if i < 0 {
+		//a length never need more than a few digit
+		if len(b)-pos > 32 {
+			return 0, 0, errors.New("invalid length line")
+		}
+		return 0, 0, ErrIncomplete
+	}
+	n, err := strconv.Atoi(string(bytes.TrimRight(b[pos+1:pos+i], "\r")))
+	if err != nil {
+		return 0, 0, err
+	}
+	return n, pos + i + 1, nil
+}
+
+// append reply of GET, nil value is a nil bulk
+func AppendBulk(dst, v []byte) []byte {
+	if v == nil {
+		return append(dst, "$-1\r\n"...)
+	}
+	dst = append(dst, '$')
+	dst = strconv.AppendInt(dst, int64(len(v)), 10)
+	dst = append(dst, "\r\n"...)
+	dst = append(dst, v...)
+	return append(dst, "\r\n"...)
+}
+
+// append error reply shaped like the one of go-redis-server
+func AppendError(dst []byte, err error) []byte {
+	msg := strings.NewReplacer("\r", " ", "\n", " ").Replace(err.Error())
+	return append(dst, "-ERROR "+msg+"\r\n"...)
+}
+
+// append command as multi bulk, the way a client send it
+func AppendCommand(dst []byte, args ...string) []byte {
+	dst = append(dst, '*')
+	dst = strconv.AppendInt(dst, int64(len(args)), 10)
+	dst = append(dst, "\r\n"...)
+	for _, a := range args {
+		dst = AppendBulk(dst, []byte(a))
+	}
+	return dst
+}
@@ -0,0 +1,65 @@
+package resp
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCommand(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		args []string
+		size int
+		err  error
+	}{
+		{"multi bulk", "*2\r\n$3\r\nGET\r\n$1\r\nk\r\n", []string{"GET", "k"}, 20, nil},
+		{"trailing command ignored", "*1\r\n$4\r\nPING\r\n*1", []string{"PING"}, 14, nil},
+		{"inline", "GET k\r\n", []string{"GET", "k"}, 7, nil},
+		{"empty", "", nil, 0, ErrIncomplete},
+		{"partial header", "*2\r", nil, 0, ErrIncomplete},
+		{"partial bulk", "*2\r\n$3\r\nGET\r\n$5\r\nab", nil, 0, ErrIncomplete},
+		{"partial inline", "GET k", nil, 0, ErrIncomplete},
+		{"negative count", "*-1\r\n", nil, 0, ErrArgs},
+		{"huge count", "*3000000000000\r\n", nil, 0, ErrArgs},
+		{"count above limit", "*1048577\r\n", nil, 0, ErrArgs},
+		{"negative bulk", "*1\r\n$-5\r\n", nil, 0, ErrBulkLen},
+		{"huge bulk", "*1\r\n$9223372036854775807\r\n", nil, 0, ErrBulkLen},
+		{"bulk above limit", "*1\r\n$536870913\r\n", nil, 0, ErrBulkLen},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args, size, err := ParseCommand([]byte(tt.in))
+			if err != tt.err {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if !reflect.DeepEqual(args, tt.args) || size != tt.size {
+				t.Fatalf("got %q %d, want %q %d", args, size, tt.args, tt.size)
+			}
+		})
+	}
+}
+
+func TestParseCommandMalformed(t *testing.T) {
+	for _, in := range []string{"*x\r\n", "*1\r\n+3\r\n", "*1\r\n$abc\r\n", "*99999999999999999999999\r\n"} {
+		if _, _, err := ParseCommand([]byte(in)); err == nil || err == ErrIncomplete {
+			t.Errorf("%q : err = %v, want parse error", in, err)
+		}
+	}
+}
+
+func TestAppendReply(t *testing.T) {
+	if got := string(AppendBulk(nil, []byte("ab"))); got != "$2\r\nab\r\n" {
+		t.Errorf("bulk = %q", got)
+	}
+	if got := string(AppendBulk(nil, nil)); got != "$-1\r\n" {
+		t.Errorf("nil bulk = %q", got)
+	}
+	cmd := AppendCommand(nil, "GET", "")
+	if string(cmd) != "*2\r\n$3\r\nGET\r\n$0\r\n\r\n" {
+		t.Errorf("command = %q", cmd)
+	}
+	if args, n, err := ParseCommand(cmd); err != nil || n != len(cmd) || len(args) != 2 || args[1] != "" {
+		t.Errorf("parsed command = %q %d %v", args, n, err)
+	}
+}
@@ -0,0 +1,50 @@
+package rule
+
+import (
+	"regexp"
+	"strings"
+)
+
+// prefix used in config to mark a pattern as regular expression instead of glob
+const regexPrefix = "re:"
+
+type Matcher struct {
+	Pattern string
+	re      *regexp.Regexp
+}
+
+// compile pattern into matcher, pattern is a redis style glob (* and ?)
+// or a regular expression when prefixed with "re:"
+func NewMatcher(pattern string) (*Matcher, error) {
+	expr := globToRegexp(pattern)
+	if strings.HasPrefix(pattern, regexPrefix) {
+		expr = strings.TrimPrefix(pattern, regexPrefix)
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	return &Matcher{Pattern: pattern, re: re}, nil
+}
+
+func (m *Matcher) Match(key string) bool {
+	return m.re.MatchString(key)
+}
+
+// every * in glob become capture group, so it can be reused in rewrite
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	b.WriteString("^")
+	for _, c := range glob {
+		switch c {
+		case '*':
+			b.WriteString("(.*)")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return b.String()
+}
@@ -0,0 +1,86 @@
+package rule
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/tokopedia/redisgrator/config"
+)
+
+// migration options applied to a single key
+type Policy struct {
+	SetToDestWhenGet bool
+	MoveHash         bool
+	MoveSet          bool
+	Duplicate        bool
+	Migrate          bool
+}
+
+type policyRule struct {
+	name     string
+	priority int
+	match    *Matcher
+	cfg      *config.PolicyCfg
+}
+
+type Policies struct {
+	rules []policyRule
+}
+
+var KeyPolicy *Policies
+
+// create policies from config, rules evaluated by priority then name
+func NewPolicies(cfg map[string]*config.PolicyCfg) (*Policies, error) {
+	var p Policies
+	for name, c := range cfg {
+		m, err := NewMatcher(c.Pattern)
+		if err != nil {
+			return nil, errors.New("policy " + name + " : " + err.Error())
+		}
+		p.rules = append(p.rules, policyRule{
+			name:     name,
+			priority: c.Priority,
+			match:    m,
+			cfg:      c,
+		})
+	}
+	sort.Slice(p.rules, func(i, j int) bool {
+		if p.rules[i].priority != p.rules[j].priority {
+			return p.rules[i].priority < p.rules[j].priority
+		}
+		return p.rules[i].name < p.rules[j].name
+	})
+	return &p, nil
+}
+
+// return policy for key, first matching rule override General options
+func (p *Policies) For(key string) Policy {
+	g := config.CurrentGeneral()
+	pol := Policy{
+		SetToDestWhenGet: g.SetToDestWhenGet,
+		MoveHash:         g.MoveHash,
+		MoveSet:          g.MoveSet,
+		Duplicate:        g.Duplicate,
+		Migrate:          true,
+	}
+	if p == nil {
+		return pol
+	}
+	for _, r := range p.rules {
+		if !r.match.Match(key) {
+			continue
+		}
+		pol.SetToDestWhenGet = r.cfg.SetToDestWhenGet.Or(pol.SetToDestWhenGet)
+		pol.MoveHash = r.cfg.MoveHash.Or(pol.MoveHash)
+		pol.MoveSet = r.cfg.MoveSet.Or(pol.MoveSet)
+		pol.Duplicate = r.cfg.Duplicate.Or(pol.Duplicate)
+		pol.Migrate = r.cfg.Migrate.Or(pol.Migrate)
+		break
+	}
+	return pol
+}
+
+// policy for key using global policies
+func For(key string) Policy {
+	return KeyPolicy.For(key)
+}
@@ -0,0 +1,56 @@
+package rule
+
+import (
+	"testing"
+
+	"github.com/tokopedia/redisgrator/config"
+)
+
+func set(v bool) config.OptBool {
+	return config.OptBool{Set: true, Value: v}
+}
+
+func TestPolicyFor(t *testing.T) {
+	config.Cfg.General = config.General{MoveHash: true, SetToDestWhenGet: true}
+	p, err := NewPolicies(map[string]*config.PolicyCfg{
+		"cache":    {Pattern: "cache:*", Priority: 1, Migrate: set(false)},
+		"session":  {Pattern: "session:*", Priority: 2, Duplicate: set(true), MoveHash: set(false)},
+		"catchall": {Pattern: "*", Priority: 9, MoveSet: set(true)},
+		"shadowed": {Pattern: "cache:hot:*", Priority: 5, Migrate: set(true)},
+		"b-tie":    {Pattern: "tie:*", Priority: 3, Duplicate: set(true)},
+		"a-tie":    {Pattern: "tie:*", Priority: 3, Duplicate: set(false)},
+		"regex":    {Pattern: `re:^user:\d+$`, Priority: 0, SetToDestWhenGet: set(false)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	general := Policy{SetToDestWhenGet: true, MoveHash: true, Migrate: true}
+	tests := []struct {
+		key  string
+		want Policy
+	}{
+		//lower priority win even though a more specific pattern match too
+		{"cache:hot:1", Policy{SetToDestWhenGet: true, MoveHash: true, Migrate: false}},
+		{"session:1", Policy{SetToDestWhenGet: true, Duplicate: true, Migrate: true}},
+		//name break tie of same priority
+		{"tie:1", general},
+		{"user:1", Policy{MoveHash: true, Migrate: true}},
+		//only first matching rule apply, unset option follow General
+		{"user:x", Policy{SetToDestWhenGet: true, MoveHash: true, MoveSet: true, Migrate: true}},
+	}
+	for _, tt := range tests {
+		if got := p.For(tt.key); got != tt.want {
+			t.Errorf("For(%q) = %+v, want %+v", tt.key, got, tt.want)
+		}
+	}
+	if got := (*Policies)(nil).For("k"); got != general {
+		t.Errorf("nil policies : got %+v, want %+v", got, general)
+	}
+}
+
+func TestNewPoliciesInvalidPattern(t *testing.T) {
+	_, err := NewPolicies(map[string]*config.PolicyCfg{"bad": {Pattern: "re:("}})
+	if err == nil {
+		t.Fatal("got no error for invalid regex")
+	}
+}
@@ -0,0 +1,146 @@
+package rule
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tokopedia/redisgrator/config"
+)
+
+type rewrite struct {
+	name     string
+	priority int
+	from     *Matcher
+	to       string
+	// glob target matching destination key, nil when rule can not be reversed
+	back *Matcher
+}
+
+type Rewriter struct {
+	rules []rewrite
+}
+
+var KeyRewriter *Rewriter
+
+// create rewriter from config, rules evaluated by priority then name
+func NewRewriter(cfg map[string]*config.RewriteCfg) (*Rewriter, error) {
+	var rw Rewriter
+	for name, c := range cfg {
+		m, err := NewMatcher(c.From)
+		if err != nil {
+			return nil, errors.New("rewrite " + name + " : " + err.Error())
+		}
+		rw.rules = append(rw.rules, rewrite{
+			name:     name,
+			priority: c.Priority,
+			from:     m,
+			to:       globReplacement(c.From, c.To),
+			back:     reverseMatcher(c.From, c.To),
+		})
+	}
+	sort.Slice(rw.rules, func(i, j int) bool {
+		if rw.rules[i].priority != rw.rules[j].priority {
+			return rw.rules[i].priority < rw.rules[j].priority
+		}
+		return rw.rules[i].name < rw.rules[j].name
+	})
+	return &rw, nil
+}
+
+// return key name in destination, first matching rule wins
+func (rw *Rewriter) Rewrite(key string) string {
+	if rw == nil {
+		return key
+	}
+	for _, r := range rw.rules {
+		idx := r.from.re.FindStringSubmatchIndex(key)
+		if idx == nil {
+			continue
+		}
+		return string(r.from.re.ExpandString(nil, r.to, key, idx))
+	}
+	return key
+}
+
+// return origin key rewritten into dkey, ok is false when none is found,
+// regex rule can not be reversed so key it produced is only found when it keep its name
+func (rw *Rewriter) Source(dkey string) (key string, ok bool) {
+	if rw == nil {
+		return dkey, true
+	}
+	for _, r := range rw.rules {
+		if key, ok := r.reverse(dkey); ok && rw.Rewrite(key) == dkey {
+			return key, true
+		}
+	}
+	if rw.Rewrite(dkey) == dkey {
+		return dkey, true
+	}
+	return "", false
+}
+
+// fill every * of glob source with what the same * of glob target matched in dkey
+func (r rewrite) reverse(dkey string) (string, bool) {
+	if r.back == nil {
+		return "", false
+	}
+	m := r.back.re.FindStringSubmatch(dkey)
+	if m == nil {
+		return "", false
+	}
+	var b strings.Builder
+	n := 0
+	for _, c := range r.from.Pattern {
+		if c == '*' {
+			n++
+			b.WriteString(m[n])
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String(), true
+}
+
+// matcher of glob target, only when every * of source is carried to target as is
+func reverseMatcher(from, to string) *Matcher {
+	if strings.HasPrefix(from, regexPrefix) || strings.Contains(from, "?") ||
+		strings.Count(from, "*") != strings.Count(to, "*") {
+		return nil
+	}
+	return &Matcher{Pattern: to, re: regexp.MustCompile(globToRegexp(to))}
+}
+
+// translate every * in glob target into its capture group ($1, $2, ...),
+// regex target already use regexp expand syntax so keep it as is
+func globReplacement(from, to string) string {
+	if strings.HasPrefix(from, regexPrefix) {
+		return to
+	}
+	var b strings.Builder
+	n := 0
+	for _, c := range to {
+		switch c {
+		case '*':
+			n++
+			b.WriteString("${" + strconv.Itoa(n) + "}")
+		case '$':
+			b.WriteString("$$")
+		default:
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+// key name in destination using global rewriter
+func DestKey(key string) string {
+	return KeyRewriter.Rewrite(key)
+}
+
+// key name in origin of destination key using global rewriter
+func SourceKey(dkey string) (string, bool) {
+	return KeyRewriter.Source(dkey)
+}
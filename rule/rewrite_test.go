@@ -0,0 +1,117 @@
+package rule
+
+import (
+	"testing"
+
+	"github.com/tokopedia/redisgrator/config"
+)
+
+func newTestRewriter(t *testing.T, cfg map[string]*config.RewriteCfg) *Rewriter {
+	rw, err := NewRewriter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rw
+}
+
+func TestRewrite(t *testing.T) {
+	rw := newTestRewriter(t, map[string]*config.RewriteCfg{
+		"prefix":  {From: "user:*", To: "u:*"},
+		"swap":    {From: "order:*:*", To: "o:*:*"},
+		"single":  {From: "tmp?", To: "temp"},
+		"dollar":  {From: "price:*", To: "$:*:$1"},
+		"regex":   {From: `re:^session:(\d+)$`, To: "s:${1}"},
+		"regexfb": {From: `re:^cart:(?P<id>\w+)$`, To: "c:$id"},
+		"literal": {From: "a.b", To: "ab"},
+	})
+	tests := []struct {
+		key, want string
+	}{
+		{"user:42", "u:42"},
+		{"user:", "u:"},
+		{"order:1:2", "o:1:2"},
+		{"tmp1", "temp"},
+		{"tmp12", "tmp12"},
+		//$ in glob target is literal, not a capture reference
+		{"price:9", "$:9:$1"},
+		{"session:7", "s:7"},
+		{"session:x", "session:x"},
+		{"cart:abc", "c:abc"},
+		//glob meta char other than * and ? is literal
+		{"a.b", "ab"},
+		{"axb", "axb"},
+		{"other", "other"},
+	}
+	for _, tt := range tests {
+		if got := rw.Rewrite(tt.key); got != tt.want {
+			t.Errorf("Rewrite(%q) = %q, want %q", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestRewritePriority(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  map[string]*config.RewriteCfg
+		want string
+	}{
+		{"lower priority first", map[string]*config.RewriteCfg{
+			"a": {From: "k:*", To: "a:*", Priority: 2},
+			"b": {From: "k:*", To: "b:*", Priority: 1},
+		}, "b:1"},
+		{"name break tie", map[string]*config.RewriteCfg{
+			"b": {From: "k:*", To: "b:*"},
+			"a": {From: "k:*", To: "a:*"},
+		}, "a:1"},
+		{"non matching rule skipped", map[string]*config.RewriteCfg{
+			"a": {From: "x:*", To: "a:*", Priority: 1},
+			"b": {From: "k:*", To: "b:*", Priority: 2},
+		}, "b:1"},
+	}
+	for _, tt := range tests {
+		if got := newTestRewriter(t, tt.cfg).Rewrite("k:1"); got != tt.want {
+			t.Errorf("%s : got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestNewRewriterInvalidRegex(t *testing.T) {
+	_, err := NewRewriter(map[string]*config.RewriteCfg{"bad": {From: "re:(", To: "x"}})
+	if err == nil {
+		t.Fatal("got no error for invalid regex")
+	}
+}
+
+func TestSource(t *testing.T) {
+	rw := newTestRewriter(t, map[string]*config.RewriteCfg{
+		"prefix": {From: "user:*", To: "u:*"},
+		"two":    {From: "order:*:*", To: "o:*-*"},
+		"dollar": {From: "price:*", To: "$p:*"},
+		"regex":  {From: `re:^session:(\d+)$`, To: "s:${1}"},
+	})
+	tests := []struct {
+		dkey, want string
+		ok         bool
+	}{
+		{"u:42", "user:42", true},
+		{"o:1-2", "order:1:2", true},
+		{"$p:9", "price:9", true},
+		//key not rewritten keep its name
+		{"plain", "plain", true},
+		//origin key of that name would be rewritten away
+		{"user:1", "", false},
+		//regex rewrite can not be reversed, origin key of that name would be rewritten to s:7
+		{"session:7", "", false},
+		//only produced by regex rule but also a key rewritten to itself
+		{"s:7", "s:7", true},
+	}
+	for _, tt := range tests {
+		got, ok := rw.Source(tt.dkey)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("Source(%q) = %q, %v, want %q, %v", tt.dkey, got, ok, tt.want, tt.ok)
+		}
+	}
+	if key, ok := (*Rewriter)(nil).Source("k"); key != "k" || !ok {
+		t.Errorf("nil rewriter : got %q, %v", key, ok)
+	}
+}
@@ -0,0 +1,126 @@
+package shadow
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"time"
+
+	rds "github.com/garyburd/redigo/redis"
+	"github.com/tokopedia/redisgrator/logger"
+	"github.com/tokopedia/redisgrator/metrics"
+)
+
+// number of latest mismatch kept as sample
+const maxSamples = 100
+
+type Sample struct {
+	Time    time.Time `json:"time"`
+	Command string    `json:"command"`
+	Key     string    `json:"key"`
+	// reply of each side, redacted like log value
+	Origin      string `json:"origin"`
+	Destination string `json:"destination"`
+}
+
+var (
+	mu      sync.Mutex
+	samples []Sample
+)
+
+// compare reply of read command served by origin with the one from destination,
+// count the result and keep mismatch as sample, return true when both equal
+func Compare(cmd, key string, origin, dest interface{}) bool {
+	metrics.ShadowReads.Inc(cmd)
+	if equal(cmd, origin, dest) {
+		return true
+	}
+	metrics.ShadowMismatches.Inc(cmd)
+	s := Sample{
+		Time:        time.Now(),
+		Command:     cmd,
+		Key:         logger.Key(key),
+		Origin:      describe(origin),
+		Destination: describe(dest),
+	}
+	logger.Debug("shadow read mismatch", "command", cmd, "key", s.Key, "origin", s.Origin, "destination", s.Destination)
+	mu.Lock()
+	samples = append(samples, s)
+	if len(samples) > maxSamples {
+		samples = samples[len(samples)-maxSamples:]
+	}
+	mu.Unlock()
+	return false
+}
+
+// latest mismatch, oldest first
+func Samples() []Sample {
+	mu.Lock()
+	defer mu.Unlock()
+	return append([]Sample(nil), samples...)
+}
+
+func Reset() {
+	mu.Lock()
+	samples = nil
+	mu.Unlock()
+}
+
+// fraction of compared read that mismatched
+func MismatchRate() float64 {
+	reads := metrics.ShadowReads.Total()
+	if reads == 0 {
+		return 0
+	}
+	return float64(metrics.ShadowMismatches.Total()) / float64(reads)
+}
+
+// nil reply mean key or field does not exist,
+// HGETALL and SMEMBERS are compared regardless of order
+func equal(cmd string, a, b interface{}) bool {
+	switch cmd {
+	case "HGETALL", "SMEMBERS":
+		av, _ := rds.ByteSlices(a, nil)
+		bv, _ := rds.ByteSlices(b, nil)
+		if len(av) != len(bv) {
+			return false
+		}
+		count := make(map[string]int, len(av))
+		step := 1
+		if cmd == "HGETALL" {
+			//hash reply is field value pair
+			step = 2
+		}
+		for i := 0; i+step <= len(av); i += step {
+			count[string(bytes.Join(av[i:i+step], []byte{0}))]++
+		}
+		for i := 0; i+step <= len(bv); i += step {
+			count[string(bytes.Join(bv[i:i+step], []byte{0}))]--
+		}
+		for _, n := range count {
+			if n != 0 {
+				return false
+			}
+		}
+		return true
+	case "SISMEMBER":
+		an, _ := a.(int64)
+		bn, _ := b.(int64)
+		return an == bn
+	}
+	ab, _ := a.([]byte)
+	bb, _ := b.([]byte)
+	return (a == nil) == (b == nil) && bytes.Equal(ab, bb)
+}
+
+func describe(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "(nil)"
+	case []byte:
+		return logger.Value(v)
+	case []interface{}:
+		return fmt.Sprintf("(%d elements)", len(v))
+	}
+	return fmt.Sprint(v)
+}
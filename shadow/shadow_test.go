@@ -0,0 +1,73 @@
+package shadow
+
+import (
+	"strconv"
+	"testing"
+)
+
+func array(v ...string) []interface{} {
+	res := make([]interface{}, len(v))
+	for i, s := range v {
+		res[i] = []byte(s)
+	}
+	return res
+}
+
+func TestEqual(t *testing.T) {
+	tests := []struct {
+		cmd  string
+		a, b interface{}
+		want bool
+	}{
+		{"GET", []byte("v"), []byte("v"), true},
+		{"GET", []byte("v"), []byte("w"), false},
+		{"GET", nil, nil, true},
+		//missing key is not the same as empty string
+		{"GET", nil, []byte{}, false},
+		{"GET", []byte{}, nil, false},
+		{"HGET", []byte("v"), nil, false},
+		{"SISMEMBER", int64(1), int64(1), true},
+		{"SISMEMBER", int64(1), int64(0), false},
+		{"SMEMBERS", array("a", "b", "c"), array("c", "a", "b"), true},
+		{"SMEMBERS", array("a", "b"), array("a", "c"), false},
+		{"SMEMBERS", array("a", "a"), array("a", "b"), false},
+		{"SMEMBERS", array("a"), array("a", "b"), false},
+		//empty and missing set reply the same
+		{"SMEMBERS", array(), nil, true},
+		{"SMEMBERS", nil, array(), true},
+		{"SMEMBERS", array("a"), nil, false},
+		//pair order does not matter, field stay bound to its value
+		{"HGETALL", array("f1", "v1", "f2", "v2"), array("f2", "v2", "f1", "v1"), true},
+		{"HGETALL", array("f1", "v1", "f2", "v2"), array("f1", "v2", "f2", "v1"), false},
+		{"HGETALL", array("f1", "v1"), array("v1", "f1"), false},
+		{"HGETALL", array(), nil, true},
+		{"HGETALL", array("f1", "v1"), array(), false},
+	}
+	for _, tt := range tests {
+		if got := equal(tt.cmd, tt.a, tt.b); got != tt.want {
+			t.Errorf("equal(%s, %q, %q) = %v, want %v", tt.cmd, tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestCompareSamples(t *testing.T) {
+	Reset()
+	defer Reset()
+	if !Compare("GET", "k", []byte("v"), []byte("v")) || len(Samples()) != 0 {
+		t.Fatalf("got %v, want equal reply not sampled", Samples())
+	}
+	Compare("GET", "k", []byte("v"), nil)
+	s := Samples()
+	if len(s) != 1 || s[0].Command != "GET" || s[0].Key != "k" || s[0].Origin != "v" || s[0].Destination != "(nil)" {
+		t.Fatalf("got %+v", s)
+	}
+
+	//only latest mismatch are kept
+	for i := 0; i < maxSamples+5; i++ {
+		Compare("GET", "k"+strconv.Itoa(i), []byte("v"), nil)
+	}
+	s = Samples()
+	if len(s) != maxSamples || s[0].Key != "k5" || s[len(s)-1].Key != "k"+strconv.Itoa(maxSamples+4) {
+		t.Errorf("got %d samples from %s to %s", len(s), s[0].Key, s[len(s)-1].Key)
+	}
+}
@@ -0,0 +1,95 @@
+package slowlog
+
+import (
+	"sync"
+	"time"
+)
+
+type Entry struct {
+	ID       int64
+	Time     time.Time
+	Duration time.Duration
+	Command  string
+	Key      string
+}
+
+type slowLog struct {
+	mu        sync.Mutex
+	threshold time.Duration
+	// ring buffer, next is index of the slot written by next entry
+	entries []Entry
+	next    int
+	full    bool
+	lastID  int64
+}
+
+var current = &slowLog{threshold: -1}
+
+// keep last maxLen command slower than threshold, negative threshold or zero maxLen disable it
+func Init(threshold time.Duration, maxLen int) {
+	current.mu.Lock()
+	defer current.mu.Unlock()
+	if maxLen <= 0 {
+		threshold = -1
+		maxLen = 0
+	}
+	current.threshold = threshold
+	current.entries = make([]Entry, maxLen)
+	current.next, current.full = 0, false
+}
+
+func Threshold() time.Duration {
+	current.mu.Lock()
+	defer current.mu.Unlock()
+	return current.threshold
+}
+
+// record command when its duration exceed threshold
+func Record(cmd, key string, d time.Duration) {
+	current.mu.Lock()
+	defer current.mu.Unlock()
+	if current.threshold < 0 || d < current.threshold {
+		return
+	}
+	current.lastID++
+	current.entries[current.next] = Entry{ID: current.lastID, Time: time.Now(), Duration: d, Command: cmd, Key: key}
+	current.next++
+	if current.next == len(current.entries) {
+		current.next, current.full = 0, true
+	}
+}
+
+// return up to n entries, newest first, n < 0 return all
+func Get(n int) []Entry {
+	current.mu.Lock()
+	defer current.mu.Unlock()
+	size := current.len()
+	if n < 0 || n > size {
+		n = size
+	}
+	res := make([]Entry, 0, n)
+	for i := 1; i <= n; i++ {
+		idx := (current.next - i + len(current.entries)) % len(current.entries)
+		res = append(res, current.entries[idx])
+	}
+	return res
+}
+
+func Len() int {
+	current.mu.Lock()
+	defer current.mu.Unlock()
+	return current.len()
+}
+
+func Reset() {
+	current.mu.Lock()
+	defer current.mu.Unlock()
+	current.next, current.full = 0, false
+}
+
+func (s *slowLog) len() int {
+	if s.full {
+		return len(s.entries)
+	}
+	return s.next
+}
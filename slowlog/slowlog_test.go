@@ -0,0 +1,69 @@
+package slowlog
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func ids(entries []Entry) []int64 {
+	res := []int64{}
+	for _, e := range entries {
+		res = append(res, e.ID)
+	}
+	return res
+}
+
+func TestRing(t *testing.T) {
+	tests := []struct {
+		name    string
+		maxLen  int
+		records int
+		get     int
+		want    []int64
+	}{
+		{"empty", 3, 0, -1, []int64{}},
+		{"below capacity", 3, 2, -1, []int64{2, 1}},
+		{"exactly full", 3, 3, -1, []int64{3, 2, 1}},
+		{"wrap once", 3, 4, -1, []int64{4, 3, 2}},
+		{"wrap many", 3, 8, -1, []int64{8, 7, 6}},
+		{"limit below len", 3, 8, 2, []int64{8, 7}},
+		{"limit above len", 3, 2, 10, []int64{2, 1}},
+		{"single slot", 1, 5, -1, []int64{5}},
+		{"disabled", 0, 5, -1, []int64{}},
+	}
+	for _, tt := range tests {
+		current.lastID = 0
+		Init(time.Millisecond, tt.maxLen)
+		for i := 0; i < tt.records; i++ {
+			Record("GET", "k", time.Second)
+		}
+		got := Get(tt.get)
+		if !reflect.DeepEqual(ids(got), tt.want) {
+			t.Errorf("%s : got ids %v, want %v", tt.name, ids(got), tt.want)
+		}
+		if wantLen := len(tt.want); tt.get < 0 && Len() != wantLen {
+			t.Errorf("%s : got len %d, want %d", tt.name, Len(), wantLen)
+		}
+	}
+}
+
+func TestThresholdAndReset(t *testing.T) {
+	current.lastID = 0
+	Init(10*time.Millisecond, 4)
+	Record("GET", "fast", time.Millisecond)
+	Record("GET", "slow", 10*time.Millisecond)
+	if got := Get(-1); len(got) != 1 || got[0].Key != "slow" {
+		t.Fatalf("got %+v, want only command at threshold", got)
+	}
+
+	Reset()
+	if Len() != 0 {
+		t.Fatalf("got len %d after reset, want 0", Len())
+	}
+	//id keep growing after reset like redis
+	Record("SET", "k", time.Second)
+	if got := Get(-1); len(got) != 1 || got[0].ID != 2 {
+		t.Errorf("got %+v after reset, want single entry with id 2", got)
+	}
+}
@@ -0,0 +1,128 @@
+package trace
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"math"
+	mrand "math/rand"
+	"sync"
+	"time"
+)
+
+// finished span handed to exporter, field follow OpenTelemetry span model
+type SpanData struct {
+	TraceID           string                 `json:"traceId"`
+	SpanID            string                 `json:"spanId"`
+	ParentSpanID      string                 `json:"parentSpanId,omitempty"`
+	Name              string                 `json:"name"`
+	Kind              string                 `json:"kind"`
+	StartTimeUnixNano int64                  `json:"startTimeUnixNano"`
+	EndTimeUnixNano   int64                  `json:"endTimeUnixNano"`
+	Attributes        map[string]interface{} `json:"attributes,omitempty"`
+	Status            Status                 `json:"status"`
+}
+
+type Status struct {
+	// STATUS_CODE_UNSET, STATUS_CODE_OK or STATUS_CODE_ERROR
+	Code    string `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type Exporter interface {
+	Export(span SpanData) error
+}
+
+// span of work, every method is safe on nil span so untraced request cost nothing
+type Span struct {
+	mu    sync.Mutex
+	data  SpanData
+	start time.Time
+	ended bool
+}
+
+var (
+	exporter Exporter
+	// fraction of request traced, 0 to 1
+	sampleRatio float64
+)
+
+// set exporter and sampling ratio, must be called before serving request
+func Init(e Exporter, ratio float64) {
+	exporter = e
+	sampleRatio = math.Max(0, math.Min(1, ratio))
+}
+
+// start root span of a new trace, return nil when request is not sampled
+func Start(name string) *Span {
+	if exporter == nil || sampleRatio == 0 || (sampleRatio < 1 && mrand.Float64() >= sampleRatio) {
+		return nil
+	}
+	return newSpan(name, "SPAN_KIND_SERVER", randomID(16), "")
+}
+
+// start child span sharing trace of s
+func (s *Span) Child(name string) *Span {
+	if s == nil {
+		return nil
+	}
+	return newSpan(name, "SPAN_KIND_CLIENT", s.data.TraceID, s.data.SpanID)
+}
+
+func newSpan(name, kind, traceID, parentID string) *Span {
+	now := time.Now()
+	return &Span{
+		start: now,
+		data: SpanData{
+			TraceID:           traceID,
+			SpanID:            randomID(8),
+			ParentSpanID:      parentID,
+			Name:              name,
+			Kind:              kind,
+			StartTimeUnixNano: now.UnixNano(),
+			Attributes:        map[string]interface{}{},
+			Status:            Status{Code: "STATUS_CODE_UNSET"},
+		},
+	}
+}
+
+func (s *Span) SetAttr(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.data.Attributes[key] = value
+	s.mu.Unlock()
+}
+
+// mark span as failed when err is not nil
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.data.Status = Status{Code: "STATUS_CODE_ERROR", Message: err.Error()}
+	s.mu.Unlock()
+}
+
+// finish span and export it, calling End more than once is no-op
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.EndTimeUnixNano = s.start.Add(time.Since(s.start)).UnixNano()
+	data := s.data
+	s.mu.Unlock()
+	exporter.Export(data)
+}
+
+func randomID(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
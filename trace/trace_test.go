@@ -0,0 +1,94 @@
+package trace
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// exported span decoded back from writer exporter output
+func spans(t *testing.T, b *bytes.Buffer) []SpanData {
+	var res []SpanData
+	dec := json.NewDecoder(b)
+	for dec.More() {
+		var s SpanData
+		if err := dec.Decode(&s); err != nil {
+			t.Fatal(err)
+		}
+		res = append(res, s)
+	}
+	return res
+}
+
+func TestExport(t *testing.T) {
+	var b bytes.Buffer
+	Init(NewWriterExporter(&b), 1)
+	defer Init(nil, 0)
+
+	root := Start("GET")
+	root.SetAttr("db.statement", "GET")
+	child := root.Child("origin GET")
+	child.SetError(errors.New("connection refused"))
+	child.SetError(nil)
+	child.End()
+	root.End()
+	//ending again does not export twice
+	root.End()
+
+	got := spans(t, &b)
+	if len(got) != 2 {
+		t.Fatalf("got %d span exported, want 2", len(got))
+	}
+	c, r := got[0], got[1]
+	if r.Kind != "SPAN_KIND_SERVER" || r.ParentSpanID != "" || len(r.TraceID) != 32 || len(r.SpanID) != 16 {
+		t.Errorf("got root %+v", r)
+	}
+	if c.Kind != "SPAN_KIND_CLIENT" || c.TraceID != r.TraceID || c.ParentSpanID != r.SpanID || c.SpanID == r.SpanID {
+		t.Errorf("got child %+v, want linked to root %s/%s", c, r.TraceID, r.SpanID)
+	}
+	if r.Attributes["db.statement"] != "GET" || r.Status.Code != "STATUS_CODE_UNSET" {
+		t.Errorf("got root attributes %v status %v", r.Attributes, r.Status)
+	}
+	if c.Status != (Status{Code: "STATUS_CODE_ERROR", Message: "connection refused"}) {
+		t.Errorf("got child status %v, want error kept", c.Status)
+	}
+	if r.EndTimeUnixNano < r.StartTimeUnixNano || r.EndTimeUnixNano < c.EndTimeUnixNano {
+		t.Errorf("got root %d-%d child end %d", r.StartTimeUnixNano, r.EndTimeUnixNano, c.EndTimeUnixNano)
+	}
+
+	//every root span start its own trace
+	a, z := Start("a"), Start("b")
+	if a.data.TraceID == z.data.TraceID {
+		t.Error("got same trace id for two root span")
+	}
+}
+
+func TestNotSampled(t *testing.T) {
+	var b bytes.Buffer
+	for _, tt := range []struct {
+		name     string
+		exporter Exporter
+		ratio    float64
+	}{
+		{"no exporter", nil, 1},
+		{"zero ratio", NewWriterExporter(&b), 0},
+		{"negative ratio", NewWriterExporter(&b), -1},
+	} {
+		Init(tt.exporter, tt.ratio)
+		s := Start("GET")
+		if s != nil {
+			t.Errorf("%s : got span, want nil", tt.name)
+		}
+		//nil span is safe to use
+		c := s.Child("origin GET")
+		c.SetAttr("k", "v")
+		c.SetError(errors.New("err"))
+		c.End()
+		s.End()
+	}
+	Init(nil, 0)
+	if b.Len() != 0 {
+		t.Errorf("got %q exported, want nothing", b.String())
+	}
+}
@@ -0,0 +1,309 @@
+package verify
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	rds "github.com/garyburd/redigo/redis"
+	"github.com/tokopedia/redisgrator/connection"
+	"github.com/tokopedia/redisgrator/logger"
+	"github.com/tokopedia/redisgrator/phase"
+	"github.com/tokopedia/redisgrator/rule"
+)
+
+// max mismatch kept by background job, the rest is only counted
+const maxReport = 1000
+
+type Options struct {
+	// SCAN MATCH pattern on origin key name, empty mean whole keyspace. destination key is
+	// renamed back to its origin name by the rewrite rules then matched with the same glob,
+	// so destination keyspace is scanned whole
+	Pattern string
+	// max key compared per second, 0 mean unlimited
+	Rate int
+	// SCAN COUNT hint
+	Count int
+}
+
+type Summary struct {
+	Pattern   string    `json:"pattern"`
+	Running   bool      `json:"running"`
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end,omitempty"`
+	Scanned   int       `json:"scanned"`
+	Skipped   int       `json:"skipped"`
+	Missing   int       `json:"missing"`
+	Extra     int       `json:"extra"`
+	Different int       `json:"different"`
+	// key found only on side the current phase delete moved key from, not a mismatch
+	Expected   int    `json:"expected"`
+	Errors     int    `json:"errors"`
+	LastError  string `json:"lastError,omitempty"`
+	Mismatches int    `json:"mismatches"`
+}
+
+func (s *Summary) add(m *Mismatch) {
+	s.Mismatches++
+	switch m.Kind {
+	case KindMissing:
+		s.Missing++
+	case KindExtra:
+		s.Extra++
+	case KindDifferent:
+		s.Different++
+	}
+}
+
+// key missing on the side moved key is deleted from in p, or written only to the other side
+func Expected(p phase.Phase, m *Mismatch) bool {
+	if p == phase.Rollback {
+		return m.Kind == KindMissing
+	}
+	return m.Kind == KindExtra && p.DeletesSource()
+}
+
+// iterate origin then destination keyspace with SCAN and compare every migrated key at most
+// opts.Rate key per second, key of destination is only compared when it is missing in origin.
+// report is called for every mismatch, scan stop early when stop is closed
+func Scan(opts Options, stop <-chan struct{}, report func(Mismatch), progress func(Summary)) (Summary, error) {
+	sum := Summary{Pattern: opts.Pattern, Running: true, Start: time.Now()}
+	var tick <-chan time.Time
+	if opts.Rate > 0 {
+		t := time.NewTicker(time.Second / time.Duration(opts.Rate))
+		defer t.Stop()
+		tick = t.C
+	}
+	args := rds.Args{}
+	if opts.Count > 0 {
+		args = args.Add("COUNT", opts.Count)
+	}
+	//pattern name origin key, rewritten destination key may not match it
+	match, err := matcher(opts.Pattern)
+	if err != nil {
+		return sum, err
+	}
+	origArgs := args
+	if match != nil {
+		origArgs = append(rds.Args{"MATCH", opts.Pattern}, args...)
+	}
+	p := phase.Current()
+
+	fail := func(key string, err error) {
+		sum.Errors++
+		sum.LastError = err.Error()
+		logger.Warn("verify : err when compare key", "key", logger.Key(key), "err", err)
+	}
+	compare := func(key string) {
+		sum.Scanned++
+		m, err := Key(key)
+		if err == ErrNotFound {
+			//deleted since scanned
+			return
+		}
+		if err != nil {
+			fail(key, err)
+			return
+		}
+		switch {
+		case m == nil:
+		case Expected(p, m):
+			sum.Expected++
+		default:
+			sum.add(m)
+			report(*m)
+		}
+	}
+	sides := []struct {
+		pool  interface{ Get() rds.Conn }
+		args  rds.Args
+		check func(key string)
+	}{
+		{connection.RedisPoolConnection.Origin, origArgs, func(key string) {
+			if !rule.For(key).Migrate {
+				sum.Skipped++
+				return
+			}
+			compare(key)
+		}},
+		{connection.RedisPoolConnection.Destination, args, func(dkey string) {
+			key, ok := rule.SourceKey(dkey)
+			if ok && match != nil && !match.Match(key) {
+				return
+			}
+			if !ok || !rule.For(key).Migrate {
+				sum.Skipped++
+				return
+			}
+			found, err := inOrigin(key)
+			if err != nil {
+				fail(key, err)
+				return
+			}
+			//key on both side is compared while scanning origin already
+			if !found {
+				compare(key)
+			}
+		}},
+	}
+
+	for _, side := range sides {
+		err := scanSide(side.pool, side.args, func(key string) error {
+			select {
+			case <-stop:
+				return errors.New("verification stopped")
+			default:
+			}
+			if tick != nil {
+				<-tick
+			}
+			side.check(key)
+			return nil
+		}, func() {
+			if progress != nil {
+				progress(sum)
+			}
+		})
+		if err != nil {
+			sum.Running, sum.End = false, time.Now()
+			return sum, err
+		}
+	}
+	sum.Running, sum.End = false, time.Now()
+	return sum, nil
+}
+
+// matcher of destination key renamed back to origin, nil for whole keyspace. only * and ?
+// are supported so origin SCAN MATCH and the matcher agree on every key
+func matcher(pattern string) (*rule.Matcher, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	if strings.HasPrefix(pattern, "re:") || strings.ContainsAny(pattern, `[]\`) {
+		return nil, errors.New("pattern only support * and ? glob")
+	}
+	return rule.NewMatcher(pattern)
+}
+
+// call each for every key of pool keyspace and page once every page is done
+func scanSide(pool interface{ Get() rds.Conn }, args rds.Args, each func(key string) error, page func()) error {
+	cursor := "0"
+	for {
+		keys, next, err := scanPage(pool, cursor, args)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if err := each(key); err != nil {
+				return err
+			}
+		}
+		page()
+		cursor = next
+		if cursor == "0" {
+			return nil
+		}
+	}
+}
+
+func inOrigin(key string) (bool, error) {
+	conn := connection.RedisPoolConnection.Origin.Get()
+	defer conn.Close()
+	n, err := rds.Int(conn.Do("EXISTS", key))
+	if err != nil {
+		return false, errors.New("EXISTS : origin : " + err.Error())
+	}
+	return n > 0, nil
+}
+
+func scanPage(pool interface{ Get() rds.Conn }, cursor string, args rds.Args) ([]string, string, error) {
+	conn := pool.Get()
+	defer conn.Close()
+	reply, err := rds.Values(conn.Do("SCAN", append(rds.Args{cursor}, args...)...))
+	if err != nil {
+		return nil, "", errors.New("SCAN : " + err.Error())
+	}
+	if len(reply) != 2 {
+		return nil, "", errors.New("SCAN : unexpected reply")
+	}
+	next, err := rds.String(reply[0], nil)
+	if err != nil {
+		return nil, "", errors.New("SCAN : " + err.Error())
+	}
+	keys, err := rds.Strings(reply[1], nil)
+	if err != nil {
+		return nil, "", errors.New("SCAN : " + err.Error())
+	}
+	return keys, next, nil
+}
+
+// background verification started from admin command, only one run at a time
+type job struct {
+	mu         sync.Mutex
+	summary    Summary
+	mismatches []Mismatch
+	stop       chan struct{}
+}
+
+var current job
+
+// start background verification, fail when one is still running
+func Start(opts Options) error {
+	current.mu.Lock()
+	defer current.mu.Unlock()
+	if current.summary.Running {
+		return errors.New("verification is already running")
+	}
+	if _, err := matcher(opts.Pattern); err != nil {
+		return err
+	}
+	current.summary = Summary{Pattern: opts.Pattern, Running: true, Start: time.Now()}
+	current.mismatches = nil
+	current.stop = make(chan struct{})
+	go run(opts, current.stop)
+	return nil
+}
+
+func run(opts Options, stop <-chan struct{}) {
+	logger.Info("verify : started", "pattern", opts.Pattern, "rate", opts.Rate)
+	report := func(m Mismatch) {
+		current.mu.Lock()
+		if len(current.mismatches) < maxReport {
+			current.mismatches = append(current.mismatches, m)
+		}
+		current.mu.Unlock()
+	}
+	progress := func(s Summary) {
+		current.mu.Lock()
+		current.summary = s
+		current.mu.Unlock()
+	}
+	sum, err := Scan(opts, stop, report, progress)
+	if err != nil {
+		sum.LastError = err.Error()
+	}
+	progress(sum)
+	logger.Info("verify : finished", "scanned", sum.Scanned, "mismatches", sum.Mismatches, "errors", sum.Errors)
+}
+
+// ask running background verification to stop, it stop before comparing next key
+func Stop() error {
+	current.mu.Lock()
+	defer current.mu.Unlock()
+	if !current.summary.Running || current.stop == nil {
+		return errors.New("no verification is running")
+	}
+	close(current.stop)
+	current.stop = nil
+	return nil
+}
+
+// summary and mismatch found by last background verification
+func Report() (Summary, []Mismatch) {
+	current.mu.Lock()
+	defer current.mu.Unlock()
+	res := make([]Mismatch, len(current.mismatches))
+	copy(res, current.mismatches)
+	return current.summary, res
+}
@@ -0,0 +1,202 @@
+package verify
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	rds "github.com/garyburd/redigo/redis"
+	"github.com/tokopedia/redisgrator/connection"
+	"github.com/tokopedia/redisgrator/rule"
+)
+
+const (
+	// key, field or member exist in origin but not in destination
+	KindMissing = "missing"
+	// key, field or member exist in destination but not in origin
+	KindExtra = "extra"
+	// exist in both side with different type, value or ttl
+	KindDifferent = "different"
+)
+
+// ttl in seconds allowed to drift between both side, expiry is set at different time
+const ttlTolerance = 2
+
+// max field or member listed in mismatch, the rest is only counted in Detail
+const maxSamples = 20
+
+var ErrNotFound = errors.New("key not found in origin nor destination")
+
+type Mismatch struct {
+	Key     string `json:"key"`
+	DestKey string `json:"destKey"`
+	Kind    string `json:"kind"`
+	Type    string `json:"type"`
+	Detail  string `json:"detail"`
+	// sample of field (hash), member (set, zset) or index (list) that differ
+	Missing   []string `json:"missing,omitempty"`
+	Extra     []string `json:"extra,omitempty"`
+	Different []string `json:"different,omitempty"`
+}
+
+// compare type, value and ttl of key in origin with its rewritten key in destination,
+// return nil when both side equal
+func Key(key string) (*Mismatch, error) {
+	origConn := connection.RedisPoolConnection.Origin.Get()
+	destConn := connection.RedisPoolConnection.Destination.Get()
+	defer origConn.Close()
+	defer destConn.Close()
+	dkey := rule.DestKey(key)
+
+	typOrig, err := rds.String(origConn.Do("TYPE", key))
+	if err != nil {
+		return nil, errors.New("TYPE : origin : " + err.Error())
+	}
+	typDest, err := rds.String(destConn.Do("TYPE", dkey))
+	if err != nil {
+		return nil, errors.New("TYPE : destination : " + err.Error())
+	}
+	m := &Mismatch{Key: key, DestKey: dkey, Type: typOrig}
+	switch {
+	case typOrig == "none" && typDest == "none":
+		return nil, ErrNotFound
+	case typDest == "none":
+		m.Kind, m.Detail = KindMissing, "missing in destination"
+		return m, nil
+	case typOrig == "none":
+		m.Kind, m.Type, m.Detail = KindExtra, typDest, "missing in origin"
+		return m, nil
+	case typOrig != typDest:
+		m.Kind, m.Detail = KindDifferent, "type differ : "+typOrig+" in origin, "+typDest+" in destination"
+		return m, nil
+	}
+
+	var details []string
+	if typOrig == "string" {
+		valOrig, err := rds.Bytes(origConn.Do("GET", key))
+		if err != nil {
+			return nil, errors.New("GET : origin : " + err.Error())
+		}
+		valDest, err := rds.Bytes(destConn.Do("GET", dkey))
+		if err != nil {
+			return nil, errors.New("GET : destination : " + err.Error())
+		}
+		if string(valOrig) != string(valDest) {
+			details = append(details, "value differ")
+		}
+	} else {
+		valOrig, err := elements(origConn, typOrig, key)
+		if err != nil {
+			return nil, errors.New(typOrig + " : origin : " + err.Error())
+		}
+		valDest, err := elements(destConn, typOrig, dkey)
+		if err != nil {
+			return nil, errors.New(typOrig + " : destination : " + err.Error())
+		}
+		if d := diff(m, valOrig, valDest); d != "" {
+			details = append(details, d)
+		}
+	}
+
+	ttlOrig, err := rds.Int64(origConn.Do("TTL", key))
+	if err != nil {
+		return nil, errors.New("TTL : origin : " + err.Error())
+	}
+	ttlDest, err := rds.Int64(destConn.Do("TTL", dkey))
+	if err != nil {
+		return nil, errors.New("TTL : destination : " + err.Error())
+	}
+	if !sameTTL(ttlOrig, ttlDest) {
+		details = append(details, fmt.Sprintf("ttl differ : %d in origin, %d in destination", ttlOrig, ttlDest))
+	}
+
+	if len(details) == 0 {
+		return nil, nil
+	}
+	m.Kind, m.Detail = KindDifferent, strings.Join(details, ", ")
+	return m, nil
+}
+
+// read collection as element to value map, set member map to empty value
+// and list index map to element
+func elements(conn rds.Conn, typ, key string) (map[string]string, error) {
+	var reply [][]byte
+	var err error
+	switch typ {
+	case "hash":
+		reply, err = rds.ByteSlices(conn.Do("HGETALL", key))
+	case "zset":
+		reply, err = rds.ByteSlices(conn.Do("ZRANGE", key, 0, -1, "WITHSCORES"))
+	case "set":
+		reply, err = rds.ByteSlices(conn.Do("SMEMBERS", key))
+	case "list":
+		reply, err = rds.ByteSlices(conn.Do("LRANGE", key, 0, -1))
+	default:
+		return nil, errors.New("type " + typ + " is not supported")
+	}
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[string]string, len(reply))
+	switch typ {
+	case "hash", "zset":
+		//reply is field value (or member score) pair
+		for i := 0; i+1 < len(reply); i += 2 {
+			res[string(reply[i])] = string(reply[i+1])
+		}
+	case "set":
+		for _, v := range reply {
+			res[string(v)] = ""
+		}
+	case "list":
+		for i, v := range reply {
+			res[strconv.Itoa(i)] = string(v)
+		}
+	}
+	return res, nil
+}
+
+// fill sample of differing element in m, return empty string when equal
+func diff(m *Mismatch, orig, dest map[string]string) string {
+	var missing, extra, different []string
+	for k, v := range orig {
+		dv, ok := dest[k]
+		if !ok {
+			missing = append(missing, k)
+		} else if v != dv {
+			different = append(different, k)
+		}
+	}
+	for k := range dest {
+		if _, ok := orig[k]; !ok {
+			extra = append(extra, k)
+		}
+	}
+	if len(missing)+len(extra)+len(different) == 0 {
+		return ""
+	}
+	m.Missing, m.Extra, m.Different = sample(missing), sample(extra), sample(different)
+	return fmt.Sprintf("%d missing, %d extra, %d different element", len(missing), len(extra), len(different))
+}
+
+func sample(s []string) []string {
+	sort.Strings(s)
+	if len(s) > maxSamples {
+		return s[:maxSamples]
+	}
+	return s
+}
+
+// ttl -1 mean no expiry, both side must agree on having expiry
+func sameTTL(a, b int64) bool {
+	if a < 0 || b < 0 {
+		return a == b
+	}
+	d := a - b
+	if d < 0 {
+		d = -d
+	}
+	return d <= ttlTolerance
+}
@@ -0,0 +1,195 @@
+package verify
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tokopedia/redisgrator/config"
+	"github.com/tokopedia/redisgrator/connection"
+	"github.com/tokopedia/redisgrator/phase"
+	"github.com/tokopedia/redisgrator/redistest"
+	"github.com/tokopedia/redisgrator/rule"
+)
+
+func testPool(side string, r *redistest.Redis) *connection.Pool {
+	p := connection.NewPool(side, "", connection.Options{MaxIdle: 2,
+		BreakerErrors: 1, BreakerSuccesses: 1, BreakerTimeout: time.Hour})
+	p.Pool.Dial = redistest.Dial(r, nil)
+	return p
+}
+
+// origin and destination in memory, destination name of u:* key is d:*, keep:* is not migrated
+func setup(t *testing.T) (orig, dest *redistest.Redis) {
+	pols, err := rule.NewPolicies(map[string]*config.PolicyCfg{"keep": {Pattern: "keep:*", Migrate: config.OptBool{Set: true}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rw, err := rule.NewRewriter(map[string]*config.RewriteCfg{"user": {From: "u:*", To: "d:*"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rule.KeyPolicy, rule.KeyRewriter = pols, rw
+	t.Cleanup(func() { rule.KeyPolicy, rule.KeyRewriter = nil, nil })
+	orig, dest = redistest.New(), redistest.New()
+	connection.RedisPoolConnection = &connection.RedisPoolHost{
+		Origin:      testPool("origin", orig),
+		Destination: testPool("destination", dest),
+	}
+	return orig, dest
+}
+
+func TestKey(t *testing.T) {
+	tests := []struct {
+		name       string
+		orig, dest interface{}
+		ttlOrig    int64
+		ttlDest    int64
+		kind       string
+		detail     string
+		want       *Mismatch
+	}{
+		{name: "equal string", orig: "a", dest: "a"},
+		{name: "missing", orig: "a", kind: KindMissing, detail: "missing in destination"},
+		{name: "extra", dest: "a", kind: KindExtra, detail: "missing in origin"},
+		{name: "type", orig: "a", dest: map[string]bool{"a": true}, kind: KindDifferent,
+			detail: "type differ : string in origin, set in destination"},
+		{name: "string value", orig: "a", dest: "b", kind: KindDifferent, detail: "value differ"},
+		{name: "equal hash", orig: map[string]string{"f": "1"}, dest: map[string]string{"f": "1"}},
+		{name: "hash field", orig: map[string]string{"f1": "1", "f2": "2", "f3": "3"},
+			dest: map[string]string{"f2": "2", "f3": "x", "f4": "4"},
+			kind: KindDifferent, detail: "1 missing, 1 extra, 1 different element",
+			want: &Mismatch{Missing: []string{"f1"}, Extra: []string{"f4"}, Different: []string{"f3"}}},
+		{name: "set member", orig: map[string]bool{"m1": true, "m2": true}, dest: map[string]bool{"m1": true},
+			kind: KindDifferent, detail: "1 missing, 0 extra, 0 different element", want: &Mismatch{Missing: []string{"m2"}}},
+		{name: "zset score", orig: map[string]float64{"m": 1}, dest: map[string]float64{"m": 2},
+			kind: KindDifferent, detail: "0 missing, 0 extra, 1 different element", want: &Mismatch{Different: []string{"m"}}},
+		{name: "ttl only one side", orig: "a", dest: "a", ttlOrig: 100,
+			kind: KindDifferent, detail: "ttl differ : 100 in origin, -1 in destination"},
+		{name: "ttl drift within tolerance", orig: "a", dest: "a", ttlOrig: 100, ttlDest: 98},
+		{name: "ttl drift above tolerance", orig: "a", dest: "a", ttlOrig: 100, ttlDest: 90,
+			kind: KindDifferent, detail: "ttl differ : 100 in origin, 90 in destination"},
+		{name: "value and ttl", orig: "a", dest: "b", ttlDest: 10,
+			kind: KindDifferent, detail: "value differ, ttl differ : -1 in origin, 10 in destination"},
+	}
+	for _, tt := range tests {
+		orig, dest := setup(t)
+		//destination name of u:k is d:k
+		if tt.orig != nil {
+			orig.Data["u:k"] = tt.orig
+		}
+		if tt.dest != nil {
+			dest.Data["d:k"] = tt.dest
+		}
+		if tt.ttlOrig > 0 {
+			orig.TTL["u:k"] = tt.ttlOrig
+		}
+		if tt.ttlDest > 0 {
+			dest.TTL["d:k"] = tt.ttlDest
+		}
+		m, err := Key("u:k")
+		if err != nil {
+			t.Errorf("%s : %v", tt.name, err)
+			continue
+		}
+		if tt.kind == "" {
+			if m != nil {
+				t.Errorf("%s : got mismatch %+v, want none", tt.name, m)
+			}
+			continue
+		}
+		if m == nil {
+			t.Errorf("%s : got no mismatch, want %s", tt.name, tt.kind)
+			continue
+		}
+		if m.Kind != tt.kind || m.Detail != tt.detail || m.Key != "u:k" || m.DestKey != "d:k" {
+			t.Errorf("%s : got %s %s %q, want %s d:k %q", tt.name, m.Kind, m.DestKey, m.Detail, tt.kind, tt.detail)
+		}
+		if tt.want != nil && (!reflect.DeepEqual(m.Missing, tt.want.Missing) ||
+			!reflect.DeepEqual(m.Extra, tt.want.Extra) || !reflect.DeepEqual(m.Different, tt.want.Different)) {
+			t.Errorf("%s : got sample %v %v %v, want %v %v %v", tt.name, m.Missing, m.Extra, m.Different,
+				tt.want.Missing, tt.want.Extra, tt.want.Different)
+		}
+	}
+
+	setup(t)
+	if _, err := Key("none"); err != ErrNotFound {
+		t.Errorf("got %v for key on neither side, want ErrNotFound", err)
+	}
+}
+
+func TestScanPhase(t *testing.T) {
+	tests := []struct {
+		phase phase.Phase
+		want  Summary
+	}{
+		//key only in destination is written there without origin while origin is authoritative
+		{phase.Shadow, Summary{Missing: 1, Extra: 1, Different: 1, Mismatches: 3}},
+		{phase.DualWrite, Summary{Missing: 1, Extra: 1, Different: 1, Mismatches: 3}},
+		//moved key is deleted from origin
+		{phase.DestPrimary, Summary{Missing: 1, Different: 1, Expected: 1, Mismatches: 2}},
+		{phase.DestOnly, Summary{Missing: 1, Different: 1, Expected: 1, Mismatches: 2}},
+		//key moved back is deleted from destination
+		{phase.Rollback, Summary{Extra: 1, Different: 1, Expected: 1, Mismatches: 2}},
+	}
+	for _, tt := range tests {
+		orig, dest := setup(t)
+		if err := phase.Set(tt.phase); err != nil {
+			t.Fatal(err)
+		}
+		orig.Data["same"], dest.Data["same"] = "a", "a"
+		orig.Data["diff"], dest.Data["diff"] = "a", "b"
+		orig.Data["u:only"] = "a"
+		dest.Data["d:moved"] = "a"
+		//not migrated, compared on neither side
+		orig.Data["keep:1"], dest.Data["keep:2"] = "a", "a"
+		//destination key an origin key can not be rewritten to
+		dest.Data["u:x"] = "a"
+
+		var reported []string
+		sum, err := Scan(Options{Count: 2}, nil, func(m Mismatch) { reported = append(reported, m.Kind+" "+m.Key) }, nil)
+		if err != nil {
+			t.Fatalf("%s : %v", tt.phase, err)
+		}
+		tt.want.Scanned, tt.want.Skipped = 4, 3
+		got := Summary{Scanned: sum.Scanned, Skipped: sum.Skipped, Missing: sum.Missing, Extra: sum.Extra,
+			Different: sum.Different, Expected: sum.Expected, Errors: sum.Errors, Mismatches: sum.Mismatches}
+		if got != tt.want {
+			t.Errorf("%s : got %+v, want %+v", tt.phase, got, tt.want)
+		}
+		if len(reported) != tt.want.Mismatches {
+			t.Errorf("%s : got reported %v, want %d mismatch", tt.phase, reported, tt.want.Mismatches)
+		}
+	}
+}
+
+func TestScanPattern(t *testing.T) {
+	orig, dest := setup(t)
+	if err := phase.Set(phase.Shadow); err != nil {
+		t.Fatal(err)
+	}
+	orig.Data["u:1"], dest.Data["d:1"] = "a", "a"
+	orig.Data["u:2"] = "a"
+	orig.Data["other"] = "a"
+	//match u:* only once renamed back to origin
+	dest.Data["d:3"] = "a"
+	dest.Data["other2"] = "a"
+
+	var reported []string
+	sum, err := Scan(Options{Pattern: "u:*"}, nil, func(m Mismatch) { reported = append(reported, m.Kind+" "+m.Key) }, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{KindMissing + " u:2", KindExtra + " u:3"}
+	if !reflect.DeepEqual(reported, want) || sum.Scanned != 3 {
+		t.Errorf("got %v after %d scanned, want %v after 3", reported, sum.Scanned, want)
+	}
+
+	for _, pattern := range []string{"re:^u:", "u:[12]", `u:\*`} {
+		if _, err := Scan(Options{Pattern: pattern}, nil, func(Mismatch) {}, nil); err == nil ||
+			!strings.Contains(err.Error(), "pattern") {
+			t.Errorf("pattern %q : got %v, want pattern refused", pattern, err)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package worker
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+// task overflowing queue kept on disk as json line
+type spillFile struct {
+	mu    sync.Mutex
+	f     *os.File
+	count int
+}
+
+// open spill file, task left by previous run is loaded back too
+func openSpill(path string) (*spillFile, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s := &spillFile{f: f}
+	tasks, err := s.read()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	s.count = len(tasks)
+	return s, nil
+}
+
+func (s *spillFile) write(t Task) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.append(t)
+}
+
+// spill task only when older task is still waiting in file
+func (s *spillFile) writeIfPending(t Task) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.count == 0 {
+		return false, nil
+	}
+	return true, s.append(t)
+}
+
+func (s *spillFile) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.count
+}
+
+// hand spilled task to queue in order until it is refused, the rest is kept in file
+func (s *spillFile) refill(queue func(Task) bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tasks, err := s.read()
+	if err != nil {
+		return err
+	}
+	i := 0
+	for i < len(tasks) && queue(tasks[i]) {
+		i++
+	}
+	if err = s.f.Truncate(0); err != nil {
+		return err
+	}
+	s.count = 0
+	for _, t := range tasks[i:] {
+		if err = s.append(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *spillFile) append(t Task) error {
+	b, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	if _, err = s.f.Write(b); err != nil {
+		return err
+	}
+	s.count++
+	return nil
+}
+
+func (s *spillFile) read() ([]Task, error) {
+	if _, err := s.f.Seek(0, 0); err != nil {
+		return nil, err
+	}
+	var tasks []Task
+	sc := bufio.NewScanner(s.f)
+	sc.Buffer(make([]byte, 64*1024), 512*1024*1024)
+	for sc.Scan() {
+		var t Task
+		//partially written line of a crash is skipped
+		if json.Unmarshal(sc.Bytes(), &t) != nil {
+			continue
+		}
+		tasks = append(tasks, t)
+	}
+	return tasks, sc.Err()
+}
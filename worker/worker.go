@@ -0,0 +1,269 @@
+package worker
+
+import (
+	"errors"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/tokopedia/redisgrator/logger"
+	"github.com/tokopedia/redisgrator/metrics"
+	"github.com/tokopedia/redisgrator/trace"
+)
+
+// what to do with task submitted while queue of its key is full
+const (
+	// task is discarded and counted
+	Drop = "drop"
+	// task is queued anyway and caller wait in WaitRoom until queue is back under its size
+	Block = "block"
+	// task is appended to spill file and queued again once queue has room
+	Spill = "spill"
+)
+
+// how often spilled task is loaded back
+var reloadInterval = time.Second
+
+// background job, serialized as is to spill file
+type Task struct {
+	Kind string `json:"kind"`
+	Key  string `json:"key"`
+	Cmd  string `json:"cmd,omitempty"`
+	// []byte is kept as base64 in json so binary value survive spill
+	Args [][]byte `json:"args,omitempty"`
+	// upstream written, audit action, redis type and size of write, for task writing one side
+	Side   string `json:"side,omitempty"`
+	Action string `json:"action,omitempty"`
+	Type   string `json:"type,omitempty"`
+	Size   int    `json:"size,omitempty"`
+	// pending task of same kind and key is enough, later one is skipped
+	Dedup bool `json:"dedup,omitempty"`
+	// span of request that submitted task, lost when spilled
+	Span *trace.Span `json:"-"`
+}
+
+// fifo of one worker
+type queue struct {
+	mu    sync.Mutex
+	tasks []Task
+	// signaled when task is pushed and when task is taken
+	cond *sync.Cond
+}
+
+func newQueue() *queue {
+	q := &queue{}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+func (q *queue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.tasks)
+}
+
+// push task while queue hold less than max, any max below 0 always push
+func (q *queue) push(t Task, max int) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if max >= 0 && len(q.tasks) >= max {
+		return false
+	}
+	q.tasks = append(q.tasks, t)
+	q.cond.Broadcast()
+	return true
+}
+
+func (q *queue) pop() Task {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.tasks) == 0 {
+		q.cond.Wait()
+	}
+	t := q.tasks[0]
+	q.tasks[0] = Task{}
+	q.tasks = q.tasks[1:]
+	q.cond.Broadcast()
+	return t
+}
+
+func (q *queue) waitBelow(max int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.tasks) > max {
+		q.cond.Wait()
+	}
+}
+
+type Pool struct {
+	// one queue per worker, task of same key always go to same queue so they run in order
+	queues   []*queue
+	size     int
+	overflow string
+	exec     func(Task)
+
+	mu      sync.Mutex
+	pending map[string]bool
+
+	spill *spillFile
+}
+
+// start workers running exec for every task, each worker owns queue of queueSize task
+func New(workers, queueSize int, overflow, spillPath string, exec func(Task)) (*Pool, error) {
+	if workers <= 0 || queueSize <= 0 {
+		return nil, errors.New("worker : workers and queue size must be positive")
+	}
+	p := &Pool{
+		queues:   make([]*queue, workers),
+		size:     queueSize,
+		overflow: overflow,
+		exec:     exec,
+		pending:  make(map[string]bool),
+	}
+	switch overflow {
+	case Drop, Block:
+	case Spill:
+		if spillPath == "" {
+			return nil, errors.New("worker : spill file must be set for spill overflow")
+		}
+		s, err := openSpill(spillPath)
+		if err != nil {
+			return nil, errors.New("worker : err when open spill file : " + err.Error())
+		}
+		p.spill = s
+	default:
+		return nil, errors.New("worker : unknown overflow " + overflow)
+	}
+	for i := range p.queues {
+		p.queues[i] = newQueue()
+		go p.run(p.queues[i])
+	}
+	if p.spill != nil {
+		go p.reload()
+	}
+	metrics.WorkerQueueDepth.Set("queued", func() float64 { return float64(p.Depth()) })
+	metrics.WorkerQueueDepth.Set("spilled", func() float64 { return float64(p.Spilled()) })
+	return p, nil
+}
+
+// queue task without ever blocking, so it is safe to call while holding a lock a task may
+// need, false when task is dropped because queue is full
+func (p *Pool) Submit(t Task) bool {
+	if t.Dedup {
+		id := pendingID(t)
+		p.mu.Lock()
+		if p.pending[id] {
+			p.mu.Unlock()
+			metrics.TasksDeduplicated.Inc(t.Kind)
+			return true
+		}
+		p.pending[id] = true
+		p.mu.Unlock()
+	}
+
+	//task queued behind spilled one would run before older task of same key
+	if p.spill != nil {
+		spilled, err := p.spill.writeIfPending(t)
+		if spilled && err == nil {
+			p.done(t)
+			metrics.TasksSpilled.Inc(t.Kind)
+			return true
+		}
+	}
+
+	max := p.size
+	if p.overflow == Block {
+		max = -1
+	}
+	if p.queue(t.Key).push(t, max) {
+		metrics.TasksQueued.Inc(t.Kind)
+		return true
+	}
+
+	if p.overflow == Spill {
+		//spilled task is deduplicated again when loaded back
+		p.done(t)
+		err := p.spill.write(t)
+		if err == nil {
+			metrics.TasksSpilled.Inc(t.Kind)
+			return true
+		}
+		logger.Error("worker : err when spill task", "kind", t.Kind, "key", logger.Key(t.Key), "err", err)
+	}
+	p.done(t)
+	metrics.TasksDropped.Inc(t.Kind)
+	return false
+}
+
+// with Block overflow wait until queue of key is back under its size, caller must not
+// hold any lock a task may need
+func (p *Pool) WaitRoom(key string) {
+	if p.overflow != Block {
+		return
+	}
+	p.queue(key).waitBelow(p.size)
+}
+
+// task waiting in queue of all worker
+func (p *Pool) Depth() int {
+	n := 0
+	for _, q := range p.queues {
+		n += q.len()
+	}
+	return n
+}
+
+// task waiting in spill file
+func (p *Pool) Spilled() int {
+	if p.spill == nil {
+		return 0
+	}
+	return p.spill.len()
+}
+
+func (p *Pool) queue(key string) *queue {
+	return p.queues[shard(key, len(p.queues))]
+}
+
+func (p *Pool) run(q *queue) {
+	for {
+		t := q.pop()
+		//task submitted from now on is not covered by this run anymore
+		p.done(t)
+		p.exec(t)
+	}
+}
+
+// load spilled task back in order while queues have room
+func (p *Pool) reload() {
+	for range time.Tick(reloadInterval) {
+		if p.spill.len() == 0 {
+			continue
+		}
+		err := p.spill.refill(func(t Task) bool {
+			return p.queue(t.Key).push(t, p.size)
+		})
+		if err != nil {
+			logger.Error("worker : err when load spilled task", "err", err)
+		}
+	}
+}
+
+func (p *Pool) done(t Task) {
+	if !t.Dedup {
+		return
+	}
+	p.mu.Lock()
+	delete(p.pending, pendingID(t))
+	p.mu.Unlock()
+}
+
+func pendingID(t Task) string {
+	return t.Kind + "\x00" + t.Key
+}
+
+func shard(key string, n int) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(n))
+}
@@ -0,0 +1,190 @@
+package worker
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// exec blocked until release is closed, recording Args[0] of every task run
+type recorder struct {
+	mu      sync.Mutex
+	got     []string
+	release chan struct{}
+}
+
+func newRecorder() *recorder {
+	return &recorder{release: make(chan struct{})}
+}
+
+func (r *recorder) exec(t Task) {
+	<-r.release
+	r.mu.Lock()
+	r.got = append(r.got, string(t.Args[0]))
+	r.mu.Unlock()
+}
+
+func (r *recorder) wait(t *testing.T, n int) []string {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		r.mu.Lock()
+		got := append([]string(nil), r.got...)
+		r.mu.Unlock()
+		if len(got) >= n {
+			return got
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timeout waiting for %d task", n)
+	return nil
+}
+
+func task(key, arg string) Task {
+	return Task{Kind: "mirror", Key: key, Args: [][]byte{[]byte(arg)}}
+}
+
+func TestSpillKeepOrder(t *testing.T) {
+	reloadInterval = 10 * time.Millisecond
+	r := newRecorder()
+	p, err := New(1, 2, Spill, filepath.Join(t.TempDir(), "spill.json"), r.exec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{}
+	for i := 0; i < 10; i++ {
+		arg := string(rune('a' + i))
+		want = append(want, arg)
+		if !p.Submit(task("k", arg)) {
+			t.Fatalf("task %s dropped", arg)
+		}
+	}
+	if p.Spilled() == 0 {
+		t.Fatal("expected spilled task")
+	}
+	close(r.release)
+	// submitted while spilled task wait, must run after them
+	p.Submit(task("k", "z"))
+	want = append(want, "z")
+
+	got := r.wait(t, len(want))
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+}
+
+func TestSpillSurviveRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spill.json")
+	s, err := openSpill(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.write(Task{Kind: "mirror", Key: "k", Args: [][]byte{{0xff, 0x00}}})
+	s.f.Close()
+
+	s, err = openSpill(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.len() != 1 {
+		t.Fatalf("len = %d, want 1", s.len())
+	}
+	var got []Task
+	s.refill(func(t Task) bool {
+		got = append(got, t)
+		return true
+	})
+	if len(got) != 1 || string(got[0].Args[0]) != "\xff\x00" || s.len() != 0 {
+		t.Fatalf("got %+v, len %d", got, s.len())
+	}
+}
+
+func TestRefillStopAtFullQueue(t *testing.T) {
+	s, err := openSpill(filepath.Join(t.TempDir(), "spill.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, arg := range []string{"a", "b", "c"} {
+		s.write(task("k", arg))
+	}
+	n := 0
+	s.refill(func(Task) bool {
+		n++
+		return n == 1
+	})
+	var rest []string
+	s.refill(func(t Task) bool {
+		rest = append(rest, string(t.Args[0]))
+		return true
+	})
+	if len(rest) != 2 || rest[0] != "b" || rest[1] != "c" {
+		t.Fatalf("rest = %v, want [b c]", rest)
+	}
+}
+
+func TestDropAndDedup(t *testing.T) {
+	r := newRecorder()
+	p, err := New(1, 1, Drop, "", r.exec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// first is taken by the worker, second fill the queue
+	p.Submit(task("k", "a"))
+	time.Sleep(50 * time.Millisecond)
+	p.Submit(task("k", "b"))
+	if p.Submit(task("k", "c")) {
+		t.Fatal("task accepted by full queue")
+	}
+	close(r.release)
+	r.wait(t, 2)
+
+	r = newRecorder()
+	p, _ = New(1, 4, Drop, "", r.exec)
+	move := Task{Kind: "move", Key: "k", Args: [][]byte{[]byte("m")}, Dedup: true}
+	p.Submit(task("x", "busy"))
+	time.Sleep(50 * time.Millisecond)
+	p.Submit(move)
+	p.Submit(move)
+	if p.Depth() != 1 {
+		t.Fatalf("depth = %d, want 1 after duplicate move", p.Depth())
+	}
+	close(r.release)
+	r.wait(t, 2)
+}
+
+func TestBlockSubmitNeverBlock(t *testing.T) {
+	r := newRecorder()
+	p, err := New(1, 1, Block, "", r.exec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		// caller may hold a lock the worker need, submit must return anyway
+		for _, arg := range []string{"a", "b", "c", "d"} {
+			p.Submit(task("k", arg))
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("submit blocked on full queue")
+	}
+
+	waited := make(chan struct{})
+	go func() {
+		p.WaitRoom("k")
+		close(waited)
+	}()
+	select {
+	case <-waited:
+		t.Fatal("WaitRoom returned while queue is over its size")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(r.release)
+	<-waited
+	r.wait(t, 4)
+}